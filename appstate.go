@@ -7,6 +7,7 @@
 package whatsmeow
 
 import (
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -25,6 +26,10 @@ import (
 func (cli *Client) FetchAppState(name appstate.WAPatchName, fullSync, onlyIfNotSynced bool) error {
 	cli.appStateSyncLock.Lock()
 	defer cli.appStateSyncLock.Unlock()
+	if usage, ok := cli.appStateKeyUsage[name]; ok {
+		// The collection isn't known to be up to date again until all pending patches have been fetched
+		usage.upToDate = false
+	}
 	if fullSync {
 		err := cli.Store.AppState.DeleteAppStateVersion(string(name))
 		if err != nil {
@@ -45,6 +50,7 @@ func (cli *Client) FetchAppState(name appstate.WAPatchName, fullSync, onlyIfNotS
 
 	hasMore := true
 	wantSnapshot := fullSync
+	gotSnapshot := false
 	for hasMore {
 		patches, err := cli.fetchAppStatePatches(name, state.Version, wantSnapshot)
 		wantSnapshot = false
@@ -60,6 +66,8 @@ func (cli *Client) FetchAppState(name appstate.WAPatchName, fullSync, onlyIfNotS
 			}
 			return fmt.Errorf("failed to decode app state %s patches: %w", name, err)
 		}
+		gotSnapshot = gotSnapshot || patches.Snapshot != nil
+		cli.trackAppStateKeyUsage(name, patches)
 		wasFullSync := state.Version == 0 && patches.Snapshot != nil
 		state = newState
 		if name == appstate.WAPatchCriticalUnblockLow && wasFullSync && !cli.EmitAppStateEventsOnFullSync {
@@ -76,6 +84,12 @@ func (cli *Client) FetchAppState(name appstate.WAPatchName, fullSync, onlyIfNotS
 			cli.dispatchAppState(mutation, !fullSync || cli.EmitAppStateEventsOnFullSync)
 		}
 	}
+	if usage, ok := cli.appStateKeyUsage[name]; ok {
+		usage.upToDate = true
+	}
+	if gotSnapshot && cli.AppStateSyncKeyRetention > 0 {
+		cli.pruneAppStateSyncKeys()
+	}
 	if fullSync {
 		cli.Log.Debugf("Full sync of app state %s completed. Current version: %d", name, state.Version)
 		cli.dispatchEvent(&events.AppStateSyncComplete{Name: name})
@@ -85,6 +99,137 @@ func (cli *Client) FetchAppState(name appstate.WAPatchName, fullSync, onlyIfNotS
 	return nil
 }
 
+// Number of most recent app state sync keys that are never pruned, regardless of AppStateSyncKeyRetention.
+const minAppStateSyncKeysToKeep = 5
+
+// appStateKeyUsage contains the IDs of the app state sync keys used by the current state of a collection.
+type appStateKeyUsage struct {
+	keyIDs   map[string][]byte
+	upToDate bool
+}
+
+// trackAppStateKeyUsage records the sync keys used by the given patches. Usage is only tracked for collections
+// that have received a snapshot since the client was created, as the keys used by older state aren't known.
+//
+// This must be called with appStateSyncLock held.
+func (cli *Client) trackAppStateKeyUsage(name appstate.WAPatchName, patches *appstate.PatchList) {
+	usage, ok := cli.appStateKeyUsage[name]
+	if patches.Snapshot != nil {
+		usage = &appStateKeyUsage{keyIDs: make(map[string][]byte)}
+		cli.appStateKeyUsage[name] = usage
+	} else if !ok {
+		return
+	}
+	for _, keyID := range appStateKeyIDsInPatches(patches) {
+		if len(keyID) > 0 {
+			usage.keyIDs[string(keyID)] = keyID
+		}
+	}
+}
+
+func appStateKeyIDsInPatches(patches *appstate.PatchList) (keyIDs [][]byte) {
+	if patches.Snapshot != nil {
+		keyIDs = append(keyIDs, patches.Snapshot.GetKeyId().GetId())
+		for _, record := range patches.Snapshot.GetRecords() {
+			keyIDs = append(keyIDs, record.GetKeyId().GetId())
+		}
+	}
+	for _, patch := range patches.Patches {
+		keyIDs = append(keyIDs, patch.GetKeyId().GetId())
+		for _, mutation := range patch.GetMutations() {
+			keyIDs = append(keyIDs, mutation.GetRecord().GetKeyId().GetId())
+		}
+	}
+	return
+}
+
+// pruneAppStateSyncKeys deletes app state sync keys older than AppStateSyncKeyRetention, if the key store
+// implements store.AppStateSyncKeyPruner. Nothing is deleted unless every collection in AppStateCollections has
+// been synced from a snapshot and is up to date, and any keys used by the current state of a collection (as well
+// as keys newer than them) are kept, as the server may still send that state again in a later snapshot.
+//
+// This must be called with appStateSyncLock held, so that no other collection is being decoded at the same time.
+func (cli *Client) pruneAppStateSyncKeys() {
+	pruner, ok := cli.Store.AppStateKeys.(store.AppStateSyncKeyPruner)
+	if !ok {
+		return
+	}
+	cutoff := time.Now().Add(-cli.AppStateSyncKeyRetention).UnixMilli()
+	for _, name := range cli.appStateCollections() {
+		if usage, ok := cli.appStateKeyUsage[name]; !ok || !usage.upToDate {
+			cli.Log.Debugf("Not pruning app state sync keys as %s hasn't been fully synced yet", name)
+			return
+		}
+	}
+	// Collections that aren't synced automatically may have been fetched manually, so their keys are kept too
+	for _, usage := range cli.appStateKeyUsage {
+		for _, keyID := range usage.keyIDs {
+			key, err := cli.Store.AppStateKeys.GetAppStateSyncKey(keyID)
+			if err != nil {
+				cli.Log.Warnf("Failed to get app state sync key %X to check if keys can be pruned: %v", keyID, err)
+				return
+			} else if key != nil && key.Timestamp < cutoff {
+				cutoff = key.Timestamp
+			}
+		}
+	}
+	deleted, err := pruner.PruneAppStateSyncKeys(cutoff, minAppStateSyncKeysToKeep)
+	if err != nil {
+		cli.Log.Warnf("Failed to prune old app state sync keys: %v", err)
+	} else if deleted > 0 {
+		cli.Log.Debugf("Pruned %d app state sync keys older than %d", deleted, cutoff)
+	}
+}
+
+func (cli *Client) appStateCollections() []appstate.WAPatchName {
+	if cli.AppStateCollections != nil {
+		return cli.AppStateCollections
+	}
+	return appstate.AllPatchNames[:]
+}
+
+func (cli *Client) shouldSyncAppState(name appstate.WAPatchName) bool {
+	for _, enabledName := range cli.appStateCollections() {
+		if enabledName == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (cli *Client) fetchAppStateAfterPair() {
+	cli.Log.Debugf("Fetching app state after pairing")
+	collections := cli.appStateCollections()
+	for i, name := range collections {
+		err := cli.FetchAppState(name, false, true)
+		if err != nil {
+			cli.Log.Warnf("Failed to fetch app state %s after pairing: %v", name, err)
+		}
+		cli.dispatchEvent(&events.AppStateSyncProgress{
+			Name:      name,
+			Error:     err,
+			Completed: i + 1,
+			Total:     len(collections),
+		})
+	}
+}
+
+func (cli *Client) handleAccountSyncRequired(ts time.Time, meta *events.EventMeta) {
+	evt := &events.AccountSyncRequired{Timestamp: ts}
+	if cli.AutoResyncOnAccountSync {
+		cli.Log.Infof("Server requested account sync, resyncing all app state")
+		for _, name := range cli.appStateCollections() {
+			err := cli.FetchAppState(name, true, false)
+			if err != nil {
+				cli.Log.Warnf("Failed to resync app state %s: %v", name, err)
+				evt.Errors = append(evt.Errors, fmt.Errorf("failed to resync %s: %w", name, err))
+			}
+		}
+		evt.Resynced = true
+	}
+	cli.dispatchEventWithMeta(evt, meta)
+}
+
 func (cli *Client) filterContacts(mutations []appstate.Mutation) ([]appstate.Mutation, []store.ContactEntry) {
 	filteredMutations := mutations[:0]
 	contacts := make([]store.ContactEntry, 0, len(mutations))
@@ -205,7 +350,7 @@ func (cli *Client) dispatchAppState(mutation appstate.Mutation, dispatchEvts boo
 }
 
 func (cli *Client) downloadExternalAppStateBlob(ref *waProto.ExternalBlobReference) ([]byte, error) {
-	return cli.Download(ref)
+	return cli.Download(context.TODO(), ref)
 }
 
 func (cli *Client) fetchAppStatePatches(name appstate.WAPatchName, fromVersion uint64, snapshot bool) (*appstate.PatchList, error) {
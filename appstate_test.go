@@ -0,0 +1,252 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pfthink/whatsmeow/appstate"
+	waProto "github.com/pfthink/whatsmeow/binary/proto"
+	"github.com/pfthink/whatsmeow/store"
+)
+
+type memoryAppStateKeyStore struct {
+	keys map[string]store.AppStateSyncKey
+}
+
+func (ks *memoryAppStateKeyStore) PutAppStateSyncKey(id []byte, key store.AppStateSyncKey) error {
+	ks.keys[string(id)] = key
+	return nil
+}
+
+func (ks *memoryAppStateKeyStore) GetAppStateSyncKey(id []byte) (*store.AppStateSyncKey, error) {
+	key, ok := ks.keys[string(id)]
+	if !ok {
+		return nil, nil
+	}
+	return &key, nil
+}
+
+type pruneCall struct {
+	before     int64
+	keepLatest int
+}
+
+type pruningAppStateKeyStore struct {
+	memoryAppStateKeyStore
+	calls []pruneCall
+}
+
+func (ks *pruningAppStateKeyStore) PruneAppStateSyncKeys(before int64, keepLatest int) (int64, error) {
+	ks.calls = append(ks.calls, pruneCall{before, keepLatest})
+	return 0, nil
+}
+
+// versionOnlyAppStateStore is an app state store that only knows the versions of collections.
+type versionOnlyAppStateStore struct {
+	versions map[string]uint64
+}
+
+func (as *versionOnlyAppStateStore) PutAppStateVersion(name string, version uint64, _ [128]byte) error {
+	as.versions[name] = version
+	return nil
+}
+
+func (as *versionOnlyAppStateStore) GetAppStateVersion(name string) (uint64, [128]byte, error) {
+	return as.versions[name], [128]byte{}, nil
+}
+
+func (as *versionOnlyAppStateStore) DeleteAppStateVersion(name string) error {
+	delete(as.versions, name)
+	return nil
+}
+
+func (as *versionOnlyAppStateStore) PutAppStateMutationMACs(string, uint64, []store.AppStateMutationMAC) error {
+	return nil
+}
+
+func (as *versionOnlyAppStateStore) DeleteAppStateMutationMACs(string, [][]byte) error {
+	return nil
+}
+
+func (as *versionOnlyAppStateStore) GetAppStateMutationMAC(string, []byte) ([]byte, error) {
+	return nil, nil
+}
+
+func appStateKeyUsageOf(cli *Client, name appstate.WAPatchName) []string {
+	usage, ok := cli.appStateKeyUsage[name]
+	if !ok {
+		return nil
+	}
+	keyIDs := make([]string, 0, len(usage.keyIDs))
+	for keyID := range usage.keyIDs {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+	return keyIDs
+}
+
+func testKeyID(id string) *waProto.KeyId {
+	return &waProto.KeyId{Id: []byte(id)}
+}
+
+func TestTrackAppStateKeyUsage(t *testing.T) {
+	cli := NewClient(&store.Device{}, nil)
+	name := appstate.WAPatchRegular
+	patch := &appstate.PatchList{Patches: []*waProto.SyncdPatch{{
+		KeyId:     testKeyID("C"),
+		Mutations: []*waProto.SyncdMutation{{Record: &waProto.SyncdRecord{KeyId: testKeyID("C")}}},
+	}}}
+
+	// Without a snapshot, the keys used by the rest of the state aren't known
+	cli.trackAppStateKeyUsage(name, patch)
+	if _, ok := cli.appStateKeyUsage[name]; ok {
+		t.Fatal("Key usage was tracked without a snapshot")
+	}
+	cli.trackAppStateKeyUsage(name, &appstate.PatchList{Snapshot: &waProto.SyncdSnapshot{
+		KeyId:   testKeyID("A"),
+		Records: []*waProto.SyncdRecord{{KeyId: testKeyID("A")}, {KeyId: testKeyID("B")}, {}},
+	}})
+	if usage := appStateKeyUsageOf(cli, name); !reflect.DeepEqual(usage, []string{"A", "B"}) {
+		t.Errorf("Expected snapshot to use keys A and B, got %v", usage)
+	}
+	cli.trackAppStateKeyUsage(name, patch)
+	if usage := appStateKeyUsageOf(cli, name); !reflect.DeepEqual(usage, []string{"A", "B", "C"}) {
+		t.Errorf("Expected patches to add key C, got %v", usage)
+	}
+	// A new snapshot replaces the whole state
+	cli.trackAppStateKeyUsage(name, &appstate.PatchList{Snapshot: &waProto.SyncdSnapshot{KeyId: testKeyID("D")}})
+	if usage := appStateKeyUsageOf(cli, name); !reflect.DeepEqual(usage, []string{"D"}) {
+		t.Errorf("Expected new snapshot to only use key D, got %v", usage)
+	}
+}
+
+func TestPruneAppStateSyncKeysCutoff(t *testing.T) {
+	const retention = 30 * 24 * time.Hour
+	now := time.Now()
+	oldKeyTimestamp := now.Add(-2 * retention).UnixMilli()
+	retentionCutoff := now.Add(-retention).UnixMilli()
+	tests := []struct {
+		name string
+		// setup modifies the key usage, which initially has every collection up to date using the recent key.
+		// collections is the value of AppStateCollections, nil means all collections are synced.
+		setup          func(usage map[appstate.WAPatchName]*appStateKeyUsage)
+		collections    []appstate.WAPatchName
+		expectPrune    bool
+		expectedCutoff int64
+	}{
+		{
+			name:           "All collections use recent keys",
+			setup:          func(map[appstate.WAPatchName]*appStateKeyUsage) {},
+			expectPrune:    true,
+			expectedCutoff: retentionCutoff,
+		},
+		{
+			name: "Old key used by another collection",
+			setup: func(usage map[appstate.WAPatchName]*appStateKeyUsage) {
+				usage[appstate.WAPatchRegularHigh].keyIDs["old"] = []byte("old")
+			},
+			expectPrune:    true,
+			expectedCutoff: oldKeyTimestamp,
+		},
+		{
+			name: "Collection not synced from a snapshot",
+			setup: func(usage map[appstate.WAPatchName]*appStateKeyUsage) {
+				delete(usage, appstate.WAPatchCriticalBlock)
+			},
+		},
+		{
+			name: "Collection not up to date",
+			setup: func(usage map[appstate.WAPatchName]*appStateKeyUsage) {
+				usage[appstate.WAPatchRegularLow].upToDate = false
+			},
+		},
+		{
+			name: "Only configured collections are waited for",
+			setup: func(usage map[appstate.WAPatchName]*appStateKeyUsage) {
+				for name := range usage {
+					if name != appstate.WAPatchCriticalUnblockLow {
+						delete(usage, name)
+					}
+				}
+			},
+			collections:    []appstate.WAPatchName{appstate.WAPatchCriticalUnblockLow},
+			expectPrune:    true,
+			expectedCutoff: retentionCutoff,
+		},
+		{
+			name: "Keys of manually fetched collection are kept",
+			setup: func(usage map[appstate.WAPatchName]*appStateKeyUsage) {
+				usage[appstate.WAPatchRegular].keyIDs["old"] = []byte("old")
+				usage[appstate.WAPatchRegular].upToDate = false
+			},
+			collections:    []appstate.WAPatchName{appstate.WAPatchCriticalUnblockLow},
+			expectPrune:    true,
+			expectedCutoff: oldKeyTimestamp,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keyStore := &pruningAppStateKeyStore{memoryAppStateKeyStore{keys: map[string]store.AppStateSyncKey{
+				"old":    {Timestamp: oldKeyTimestamp},
+				"recent": {Timestamp: now.UnixMilli()},
+			}}, nil}
+			cli := NewClient(&store.Device{AppStateKeys: keyStore}, nil)
+			cli.AppStateSyncKeyRetention = retention
+			cli.AppStateCollections = test.collections
+			for _, name := range appstate.AllPatchNames {
+				cli.appStateKeyUsage[name] = &appStateKeyUsage{keyIDs: map[string][]byte{"recent": []byte("recent")}, upToDate: true}
+			}
+			test.setup(cli.appStateKeyUsage)
+
+			cli.pruneAppStateSyncKeys()
+			if !test.expectPrune {
+				if len(keyStore.calls) != 0 {
+					t.Errorf("Expected keys not to be pruned, got %+v", keyStore.calls)
+				}
+				return
+			} else if len(keyStore.calls) != 1 {
+				t.Fatalf("Expected keys to be pruned once, got %+v", keyStore.calls)
+			}
+			call := keyStore.calls[0]
+			// Allow a second of difference for the time passed since now was taken
+			if diff := call.before - test.expectedCutoff; diff < 0 || diff > time.Second.Milliseconds() {
+				t.Errorf("Expected cutoff %d, got %d", test.expectedCutoff, call.before)
+			}
+			if call.keepLatest != minAppStateSyncKeysToKeep {
+				t.Errorf("Expected %d latest keys to be kept, got %d", minAppStateSyncKeysToKeep, call.keepLatest)
+			}
+		})
+	}
+}
+
+func TestPruneAppStateSyncKeysUnsupportedStore(t *testing.T) {
+	cli := NewClient(&store.Device{AppStateKeys: &memoryAppStateKeyStore{keys: map[string]store.AppStateSyncKey{}}}, nil)
+	cli.AppStateSyncKeyRetention = time.Hour
+	for _, name := range appstate.AllPatchNames {
+		cli.appStateKeyUsage[name] = &appStateKeyUsage{upToDate: true}
+	}
+	// Must not panic when the store can't prune keys
+	cli.pruneAppStateSyncKeys()
+}
+
+func TestFetchAppStateFailureMarksOutdated(t *testing.T) {
+	appState := &versionOnlyAppStateStore{versions: map[string]uint64{string(appstate.WAPatchRegular): 5}}
+	cli := NewClient(&store.Device{AppState: appState}, nil)
+	cli.appStateKeyUsage[appstate.WAPatchRegular] = &appStateKeyUsage{keyIDs: map[string][]byte{}, upToDate: true}
+
+	// The client isn't connected, so fetching the pending patches fails
+	if err := cli.FetchAppState(appstate.WAPatchRegular, false, false); err == nil {
+		t.Fatal("Expected fetch to fail without a connection")
+	}
+	if cli.appStateKeyUsage[appstate.WAPatchRegular].upToDate {
+		t.Error("Collection is still marked as up to date after a failed fetch")
+	}
+}
@@ -136,3 +136,43 @@ func (cli *Client) GetStatusPrivacy() ([]types.StatusPrivacy, error) {
 	}
 	return outputs, nil
 }
+
+// SetStatusPrivacy changes the user's default status privacy setting (who to send status broadcasts to).
+//
+// The list is only used with the whitelist (only share with) and blacklist (my contacts except) types,
+// it should be empty for the contacts type.
+//
+// Status broadcasts sent with SendMessage will use the new setting immediately, as the recipient list is
+// fetched from the server on every send.
+func (cli *Client) SetStatusPrivacy(privacyType types.StatusPrivacyType, list []types.JID) error {
+	switch privacyType {
+	case types.StatusPrivacyTypeContacts:
+		if len(list) > 0 {
+			return fmt.Errorf("%w: list must be empty for contacts mode", ErrInvalidStatusPrivacyType)
+		}
+	case types.StatusPrivacyTypeBlacklist, types.StatusPrivacyTypeWhitelist:
+	default:
+		return ErrInvalidStatusPrivacyType
+	}
+	users := make([]waBinary.Node, len(list))
+	for i, jid := range list {
+		users[i] = waBinary.Node{
+			Tag:   "user",
+			Attrs: waBinary.Attrs{"jid": jid.ToNonAD()},
+		}
+	}
+	_, err := cli.sendIQ(infoQuery{
+		Namespace: "status",
+		Type:      iqSet,
+		To:        types.ServerJID,
+		Content: []waBinary.Node{{
+			Tag: "privacy",
+			Content: []waBinary.Node{{
+				Tag:     "list",
+				Attrs:   waBinary.Attrs{"type": string(privacyType)},
+				Content: users,
+			}},
+		}},
+	})
+	return err
+}
@@ -12,11 +12,11 @@ import (
 	"github.com/pfthink/whatsmeow/types/events"
 )
 
-func (cli *Client) handleCallEvent(node *waBinary.Node) {
+func (cli *Client) handleCallEvent(node *waBinary.Node, meta *events.EventMeta) {
 	go cli.sendAck(node)
 
 	if len(node.GetChildren()) != 1 {
-		cli.dispatchEvent(&events.UnknownCallEvent{Node: node})
+		cli.dispatchEventWithMeta(&events.UnknownCallEvent{Node: node}, meta)
 		return
 	}
 	ag := node.AttrGetter()
@@ -30,42 +30,42 @@ func (cli *Client) handleCallEvent(node *waBinary.Node) {
 	}
 	switch child.Tag {
 	case "offer":
-		cli.dispatchEvent(&events.CallOffer{
+		cli.dispatchEventWithMeta(&events.CallOffer{
 			BasicCallMeta: basicMeta,
 			CallRemoteMeta: types.CallRemoteMeta{
 				RemotePlatform: ag.String("platform"),
 				RemoteVersion:  ag.String("version"),
 			},
 			Data: &child,
-		})
+		}, meta)
 	case "offer_notice":
-		cli.dispatchEvent(&events.CallOfferNotice{
+		cli.dispatchEventWithMeta(&events.CallOfferNotice{
 			BasicCallMeta: basicMeta,
 			Media:         cag.String("media"),
 			Type:          cag.String("type"),
 			Data:          &child,
-		})
+		}, meta)
 	case "relaylatency":
-		cli.dispatchEvent(&events.CallRelayLatency{
+		cli.dispatchEventWithMeta(&events.CallRelayLatency{
 			BasicCallMeta: basicMeta,
 			Data:          &child,
-		})
+		}, meta)
 	case "accept":
-		cli.dispatchEvent(&events.CallAccept{
+		cli.dispatchEventWithMeta(&events.CallAccept{
 			BasicCallMeta: basicMeta,
 			CallRemoteMeta: types.CallRemoteMeta{
 				RemotePlatform: ag.String("platform"),
 				RemoteVersion:  ag.String("version"),
 			},
 			Data: &child,
-		})
+		}, meta)
 	case "terminate":
-		cli.dispatchEvent(&events.CallTerminate{
+		cli.dispatchEventWithMeta(&events.CallTerminate{
 			BasicCallMeta: basicMeta,
 			Reason:        cag.String("reason"),
 			Data:          &child,
-		})
+		}, meta)
 	default:
-		cli.dispatchEvent(&events.UnknownCallEvent{Node: node})
+		cli.dispatchEventWithMeta(&events.UnknownCallEvent{Node: node}, meta)
 	}
 }
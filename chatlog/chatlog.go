@@ -0,0 +1,207 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package chatlog implements an in-memory ordered view of conversations that is updated from whatsmeow events.
+package chatlog
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pfthink/whatsmeow"
+	waProto "github.com/pfthink/whatsmeow/binary/proto"
+	"github.com/pfthink/whatsmeow/types"
+	"github.com/pfthink/whatsmeow/types/events"
+)
+
+// Entry is a single message in a ChatLog.
+type Entry struct {
+	Info    types.MessageInfo
+	Message *waProto.Message
+
+	// Revoked is set to true when the message is deleted for everyone. The Message field is cleared when that happens.
+	Revoked bool
+	// Reactions contains the current reaction emoji of each user who has reacted to the message.
+	Reactions map[types.JID]string
+}
+
+type chat struct {
+	entries []*Entry
+	byID    map[types.MessageID]*Entry
+}
+
+// ChatLog folds message, revocation and reaction events into per-chat message lists ordered by timestamp.
+//
+// To use it, register HandleEvent as an event handler:
+//   log := chatlog.New(1000)
+//   cli.AddEventHandler(log.HandleEvent, cli)
+//
+// Note that message edits aren't handled, as the protobuf definitions don't support them yet.
+type ChatLog struct {
+	// MaxMessagesPerChat is the maximum number of messages to keep in each chat. If the limit is reached,
+	// the oldest messages are dropped. Zero means there's no limit.
+	MaxMessagesPerChat int
+	// IsGroupAdmin is used to check whether a user who revoked someone else's message in a group is an admin.
+	// If it's nil, only revokes by the sender of the message are applied.
+	IsGroupAdmin func(group, user types.JID) bool
+
+	chats map[types.JID]*chat
+	lock  sync.RWMutex
+}
+
+// New creates a new empty ChatLog that keeps at most the given number of messages per chat.
+func New(maxMessagesPerChat int) *ChatLog {
+	return &ChatLog{
+		MaxMessagesPerChat: maxMessagesPerChat,
+		chats:              make(map[types.JID]*chat),
+	}
+}
+
+// HandleEvent applies the given event to the chat log. It can be passed directly to Client.AddEventHandler.
+func (cl *ChatLog) HandleEvent(rawEvt interface{}, _ *whatsmeow.Client) {
+	switch evt := rawEvt.(type) {
+	case *events.Message:
+		cl.lock.Lock()
+		defer cl.lock.Unlock()
+		switch {
+		case evt.Message.GetReactionMessage() != nil:
+			cl.applyReaction(evt.Info, evt.Message.GetReactionMessage())
+		case evt.Message.GetProtocolMessage() != nil:
+			// Revokes are handled using the separate MessageRevoke event
+		default:
+			cl.insert(&Entry{Info: evt.Info, Message: evt.Message})
+		}
+	case *events.MessageRevoke:
+		cl.lock.Lock()
+		defer cl.lock.Unlock()
+		cl.applyRevoke(evt)
+	}
+}
+
+func (cl *ChatLog) getChat(jid types.JID) *chat {
+	c, ok := cl.chats[jid]
+	if !ok {
+		c = &chat{byID: make(map[types.MessageID]*Entry)}
+		cl.chats[jid] = c
+	}
+	return c
+}
+
+func (cl *ChatLog) insert(entry *Entry) {
+	c := cl.getChat(entry.Info.Chat)
+	if existing, ok := c.byID[entry.Info.ID]; ok {
+		// Duplicate delivery (e.g. a retried message), keep the reactions that were already applied
+		existing.Info = entry.Info
+		existing.Message = entry.Message
+		return
+	}
+	index := sort.Search(len(c.entries), func(i int) bool {
+		return c.entries[i].Info.Timestamp.After(entry.Info.Timestamp)
+	})
+	c.entries = append(c.entries, nil)
+	copy(c.entries[index+1:], c.entries[index:])
+	c.entries[index] = entry
+	c.byID[entry.Info.ID] = entry
+	if cl.MaxMessagesPerChat > 0 && len(c.entries) > cl.MaxMessagesPerChat {
+		dropCount := len(c.entries) - cl.MaxMessagesPerChat
+		for _, dropped := range c.entries[:dropCount] {
+			delete(c.byID, dropped.Info.ID)
+		}
+		c.entries = c.entries[dropCount:]
+	}
+}
+
+func (cl *ChatLog) findEntry(chatJID types.JID, id types.MessageID) *Entry {
+	c, ok := cl.chats[chatJID]
+	if !ok {
+		return nil
+	}
+	return c.byID[id]
+}
+
+func (cl *ChatLog) applyRevoke(evt *events.MessageRevoke) {
+	entry := cl.findEntry(evt.Chat, evt.MessageID)
+	if entry == nil {
+		return
+	}
+	// The sender in the revoke event comes from the revoke message itself, so check against the stored message instead
+	isSender := entry.Info.Sender.ToNonAD() == evt.RevokedBy
+	isAdmin := entry.Info.IsGroup && cl.IsGroupAdmin != nil && cl.IsGroupAdmin(evt.Chat, evt.RevokedBy)
+	if isSender || isAdmin {
+		entry.Revoked = true
+		entry.Message = nil
+	}
+}
+
+func (cl *ChatLog) applyReaction(info types.MessageInfo, reaction *waProto.ReactionMessage) {
+	entry := cl.findEntry(info.Chat, reaction.GetKey().GetId())
+	if entry == nil {
+		return
+	}
+	sender := info.Sender.ToNonAD()
+	if len(reaction.GetText()) == 0 {
+		delete(entry.Reactions, sender)
+		return
+	}
+	if entry.Reactions == nil {
+		entry.Reactions = make(map[types.JID]string)
+	}
+	entry.Reactions[sender] = reaction.GetText()
+}
+
+// Chats returns the JIDs of all chats that have messages in the log.
+func (cl *ChatLog) Chats() []types.JID {
+	cl.lock.RLock()
+	defer cl.lock.RUnlock()
+	jids := make([]types.JID, 0, len(cl.chats))
+	for jid, c := range cl.chats {
+		if len(c.entries) > 0 {
+			jids = append(jids, jid)
+		}
+	}
+	return jids
+}
+
+// Messages returns a snapshot of the messages in the given chat, oldest first.
+func (cl *ChatLog) Messages(chatJID types.JID) []Entry {
+	cl.lock.RLock()
+	defer cl.lock.RUnlock()
+	c, ok := cl.chats[chatJID]
+	if !ok {
+		return nil
+	}
+	entries := make([]Entry, len(c.entries))
+	for i, entry := range c.entries {
+		entries[i] = entry.copy()
+	}
+	return entries
+}
+
+// Get returns a snapshot of a single message in the given chat.
+func (cl *ChatLog) Get(chatJID types.JID, id types.MessageID) (Entry, bool) {
+	cl.lock.RLock()
+	defer cl.lock.RUnlock()
+	c, ok := cl.chats[chatJID]
+	if !ok {
+		return Entry{}, false
+	}
+	entry, ok := c.byID[id]
+	if !ok {
+		return Entry{}, false
+	}
+	return entry.copy(), true
+}
+
+func (entry *Entry) copy() Entry {
+	cp := *entry
+	if entry.Reactions != nil {
+		cp.Reactions = make(map[types.JID]string, len(entry.Reactions))
+		for jid, emoji := range entry.Reactions {
+			cp.Reactions[jid] = emoji
+		}
+	}
+	return cp
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package chatlog
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	waProto "github.com/pfthink/whatsmeow/binary/proto"
+	"github.com/pfthink/whatsmeow/types"
+	"github.com/pfthink/whatsmeow/types/events"
+)
+
+var (
+	testGroup = types.NewJID("123456-789", types.GroupServer)
+	alice     = types.NewJID("1111", types.DefaultUserServer)
+	bob       = types.NewJID("2222", types.DefaultUserServer)
+	baseTime  = time.Unix(1650000000, 0)
+)
+
+func textMessage(id types.MessageID, sender types.JID, offset int, text string) *events.Message {
+	return &events.Message{
+		Info: types.MessageInfo{
+			MessageSource: types.MessageSource{Chat: testGroup, Sender: sender, IsGroup: true},
+			ID:            id,
+			Timestamp:     baseTime.Add(time.Duration(offset) * time.Second),
+		},
+		Message: &waProto.Message{Conversation: proto.String(text)},
+	}
+}
+
+func reaction(id types.MessageID, sender types.JID, target types.MessageID, emoji string) *events.Message {
+	evt := textMessage(id, sender, 100, "")
+	evt.Message = &waProto.Message{ReactionMessage: &waProto.ReactionMessage{
+		Key:  &waProto.MessageKey{Id: proto.String(target)},
+		Text: proto.String(emoji),
+	}}
+	return evt
+}
+
+func messageIDs(entries []Entry) (ids []types.MessageID) {
+	for _, entry := range entries {
+		ids = append(ids, entry.Info.ID)
+	}
+	return
+}
+
+func TestChatLogOrdering(t *testing.T) {
+	cl := New(0)
+	cl.HandleEvent(textMessage("B", alice, 2, "second"), nil)
+	cl.HandleEvent(textMessage("C", bob, 3, "third"), nil)
+	cl.HandleEvent(textMessage("A", bob, 1, "first"), nil)
+	if ids := fmt.Sprint(messageIDs(cl.Messages(testGroup))); ids != "[A B C]" {
+		t.Errorf("Expected messages in timestamp order, got %s", ids)
+	}
+}
+
+func TestChatLogDedup(t *testing.T) {
+	cl := New(0)
+	cl.HandleEvent(textMessage("A", alice, 1, "original"), nil)
+	cl.HandleEvent(reaction("R", bob, "A", "👍"), nil)
+	cl.HandleEvent(textMessage("A", alice, 1, "retried"), nil)
+	entries := cl.Messages(testGroup)
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 message after duplicate delivery, got %d", len(entries))
+	}
+	if entries[0].Message.GetConversation() != "retried" {
+		t.Errorf("Expected duplicate to replace the message content, got %q", entries[0].Message.GetConversation())
+	}
+	if entries[0].Reactions[bob] != "👍" {
+		t.Errorf("Expected reactions to be kept after duplicate delivery, got %v", entries[0].Reactions)
+	}
+}
+
+func TestChatLogEviction(t *testing.T) {
+	cl := New(3)
+	for i := 5; i > 0; i-- {
+		cl.HandleEvent(textMessage(fmt.Sprintf("%d", i), alice, i, "hi"), nil)
+	}
+	if ids := fmt.Sprint(messageIDs(cl.Messages(testGroup))); ids != "[3 4 5]" {
+		t.Errorf("Expected oldest messages to be evicted, got %s", ids)
+	}
+	if _, ok := cl.Get(testGroup, "1"); ok {
+		t.Error("Evicted message can still be found by ID")
+	}
+}
+
+func TestChatLogRevoke(t *testing.T) {
+	tests := []struct {
+		name      string
+		revokedBy types.JID
+		isAdmin   func(group, user types.JID) bool
+		revoked   bool
+	}{
+		{"BySender", alice, nil, true},
+		{"ByOtherUser", bob, nil, false},
+		{"ByNonAdmin", bob, func(group, user types.JID) bool { return false }, false},
+		{"ByAdmin", bob, func(group, user types.JID) bool { return group == testGroup && user == bob }, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cl := New(0)
+			cl.IsGroupAdmin = test.isAdmin
+			cl.HandleEvent(textMessage("A", alice.ToNonAD(), 1, "hello"), nil)
+			cl.HandleEvent(&events.MessageRevoke{Chat: testGroup, Sender: alice, RevokedBy: test.revokedBy, MessageID: "A"}, nil)
+			entry, _ := cl.Get(testGroup, "A")
+			if entry.Revoked != test.revoked {
+				t.Errorf("Expected revoked to be %t, got %t", test.revoked, entry.Revoked)
+			} else if test.revoked && entry.Message != nil {
+				t.Error("Revoked message content wasn't cleared")
+			} else if !test.revoked && entry.Message.GetConversation() != "hello" {
+				t.Error("Rejected revoke changed the message content")
+			}
+		})
+	}
+}
+
+func TestChatLogReactions(t *testing.T) {
+	cl := New(0)
+	cl.HandleEvent(textMessage("A", alice, 1, "hello"), nil)
+	cl.HandleEvent(reaction("R1", bob, "A", "👍"), nil)
+	cl.HandleEvent(reaction("R2", alice, "A", "❤️"), nil)
+	cl.HandleEvent(reaction("R3", bob, "A", "😂"), nil)
+	entry, _ := cl.Get(testGroup, "A")
+	if len(entry.Reactions) != 2 || entry.Reactions[bob] != "😂" || entry.Reactions[alice] != "❤️" {
+		t.Fatalf("Unexpected reactions after changing reaction: %v", entry.Reactions)
+	}
+	cl.HandleEvent(reaction("R4", bob, "A", ""), nil)
+	entry, _ = cl.Get(testGroup, "A")
+	if _, ok := entry.Reactions[bob]; ok || len(entry.Reactions) != 1 {
+		t.Errorf("Expected empty reaction to remove the reaction, got %v", entry.Reactions)
+	}
+}
+
+func TestChatLogUnknownChat(t *testing.T) {
+	cl := New(0)
+	otherChat := types.NewJID("3333", types.DefaultUserServer)
+	cl.HandleEvent(&events.MessageRevoke{Chat: otherChat, RevokedBy: alice, MessageID: "A"}, nil)
+	unknownReaction := reaction("R", bob, "A", "👍")
+	unknownReaction.Info.Chat = otherChat
+	cl.HandleEvent(unknownReaction, nil)
+	if len(cl.chats) != 0 {
+		t.Errorf("Revokes and reactions to unknown messages created %d chat entries", len(cl.chats))
+	}
+}
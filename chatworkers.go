@@ -0,0 +1,104 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	waBinary "github.com/pfthink/whatsmeow/binary"
+	"github.com/pfthink/whatsmeow/types"
+)
+
+// chatWorkerPool processes incoming messages in parallel. Each chat is always routed to the same worker,
+// so messages within a chat are handled (and their events dispatched) in the order they were received.
+type chatWorkerPool struct {
+	queues  []chan *incomingNode
+	pending int64
+	drained chan struct{}
+
+	// offlineOnly makes the pool only accept messages that were queued on the server while the client was offline.
+	offlineOnly bool
+	handle      func(item *incomingNode)
+}
+
+func (cli *Client) startChatWorkers(ctx context.Context, wg *sync.WaitGroup, count int, offlineOnly bool) *chatWorkerPool {
+	pool := &chatWorkerPool{
+		queues:      make([]chan *incomingNode, count),
+		drained:     make(chan struct{}, 1),
+		offlineOnly: offlineOnly,
+		handle: func(item *incomingNode) {
+			cli.nodeHandlers[item.node.Tag](item.node, item.meta)
+		},
+	}
+	for i := range pool.queues {
+		queue := make(chan *incomingNode, handlerQueueSize/count)
+		pool.queues[i] = queue
+		cli.goConn(wg, func() { pool.workerLoop(ctx, queue) })
+	}
+	return pool
+}
+
+func (pool *chatWorkerPool) workerLoop(ctx context.Context, queue <-chan *incomingNode) {
+	for {
+		select {
+		case item := <-queue:
+			pool.handle(item)
+			if atomic.AddInt64(&pool.pending, -1) == 0 {
+				select {
+				case pool.drained <- struct{}{}:
+				default:
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func messageChatKey(node *waBinary.Node) string {
+	ag := node.AttrGetter()
+	from := ag.JID("from")
+	// Messages sent from our other devices in DMs have the other user in the recipient attribute
+	if recipient := ag.OptionalJIDOrEmpty("recipient"); !recipient.IsEmpty() && from.Server == types.DefaultUserServer {
+		return recipient.ToNonAD().String()
+	}
+	return from.ToNonAD().String()
+}
+
+// enqueue passes the node to a worker if it's a message the pool should handle. It returns false if the node
+// should be handled normally instead.
+func (pool *chatWorkerPool) enqueue(ctx context.Context, item *incomingNode) bool {
+	if item.node.Tag != "message" {
+		return false
+	} else if _, isOffline := item.node.Attrs["offline"]; pool.offlineOnly && !isOffline {
+		return false
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(messageChatKey(item.node)))
+	queue := pool.queues[hash.Sum32()%uint32(len(pool.queues))]
+	atomic.AddInt64(&pool.pending, 1)
+	select {
+	case queue <- item:
+	case <-ctx.Done():
+	}
+	return true
+}
+
+// wait waits until all the queued messages have been processed. It returns false if the context was canceled.
+func (pool *chatWorkerPool) wait(ctx context.Context) bool {
+	for atomic.LoadInt64(&pool.pending) > 0 {
+		select {
+		case <-pool.drained:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
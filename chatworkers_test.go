@@ -0,0 +1,232 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mau.fi/libsignal/ecc"
+	"go.mau.fi/libsignal/keys/identity"
+	"go.mau.fi/libsignal/protocol"
+
+	waBinary "github.com/pfthink/whatsmeow/binary"
+	"github.com/pfthink/whatsmeow/store"
+	"github.com/pfthink/whatsmeow/types"
+	"github.com/pfthink/whatsmeow/types/events"
+	"github.com/pfthink/whatsmeow/util/keys"
+)
+
+func TestChatWorkerPoolOrdering(t *testing.T) {
+	const messagesPerChat = 50
+	chats := []types.JID{
+		types.NewJID("1111", types.DefaultUserServer),
+		types.NewJID("2222", types.DefaultUserServer),
+		types.NewJID("123456-789", types.GroupServer),
+	}
+	slowChat, fastChat := chats[0].String(), chats[1].String()
+	fastChatDone := make(chan struct{})
+
+	var lock sync.Mutex
+	handled := make(map[string][]string)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pool := &chatWorkerPool{
+		queues:  make([]chan *incomingNode, 8),
+		drained: make(chan struct{}, 1),
+		handle: func(item *incomingNode) {
+			node := item.node
+			chat := messageChatKey(node)
+			id := node.Attrs["id"].(string)
+			if chat == slowChat {
+				if id == slowChat+"-0" {
+					// The first message of the slow chat can only finish if other chats are handled in parallel
+					select {
+					case <-fastChatDone:
+					case <-ctx.Done():
+					}
+				} else if len(id)%2 == 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+			lock.Lock()
+			handled[chat] = append(handled[chat], id)
+			if chat == fastChat && len(handled[chat]) == messagesPerChat {
+				close(fastChatDone)
+			}
+			lock.Unlock()
+		},
+	}
+	for i := range pool.queues {
+		pool.queues[i] = make(chan *incomingNode, messagesPerChat*len(chats))
+		go pool.workerLoop(ctx, pool.queues[i])
+	}
+
+	for i := 0; i < messagesPerChat; i++ {
+		for _, chat := range chats {
+			attrs := waBinary.Attrs{
+				"id":   fmt.Sprintf("%s-%d", chat, i),
+				"from": chat,
+			}
+			if chat.Server == types.GroupServer {
+				attrs["participant"] = types.NewJID(fmt.Sprintf("%d", 3333+i%3), types.DefaultUserServer)
+			}
+			if !pool.enqueue(ctx, &incomingNode{node: &waBinary.Node{Tag: "message", Attrs: attrs}}) {
+				t.Fatal("Pool didn't accept message node")
+			}
+		}
+	}
+	if pool.enqueue(ctx, &incomingNode{node: &waBinary.Node{Tag: "receipt", Attrs: waBinary.Attrs{"from": chats[0]}}}) {
+		t.Fatal("Pool accepted non-message node")
+	}
+	if !pool.wait(ctx) {
+		t.Fatal("Timed out waiting for messages to be handled (chats aren't handled in parallel?)")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	for _, chat := range chats {
+		ids := handled[chat.String()]
+		if len(ids) != messagesPerChat {
+			t.Fatalf("Expected %d messages in %s, got %d", messagesPerChat, chat, len(ids))
+		}
+		for i, id := range ids {
+			if expected := fmt.Sprintf("%s-%d", chat, i); id != expected {
+				t.Fatalf("Message %d in %s was %s, expected %s", i, chat, id, expected)
+			}
+		}
+	}
+}
+
+// sessionAccessRecorder is a session store that detects concurrent access to the session of the same address.
+// It never has any sessions, so all decryption attempts fail after checking the store.
+type sessionAccessRecorder struct {
+	store.SessionStore
+
+	lock     sync.Mutex
+	inFlight map[string]int
+	calls    map[string]int
+	overlaps []string
+	// delay is called outside the lock for every access, to make some decryptions slower than others
+	delay func(address string, call int)
+}
+
+func (sar *sessionAccessRecorder) HasSession(address string) (bool, error) {
+	sar.lock.Lock()
+	sar.inFlight[address]++
+	if sar.inFlight[address] > 1 {
+		sar.overlaps = append(sar.overlaps, address)
+	}
+	sar.calls[address]++
+	call := sar.calls[address]
+	sar.lock.Unlock()
+
+	sar.delay(address, call)
+
+	sar.lock.Lock()
+	sar.inFlight[address]--
+	sar.lock.Unlock()
+	return false, nil
+}
+
+func testSignalMessage(t *testing.T) []byte {
+	keyPair := keys.NewKeyPair()
+	pub := ecc.NewDjbECPublicKey(*keyPair.Pub)
+	msg, err := protocol.NewSignalMessage(
+		protocol.CurrentVersion, 0, 0, make([]byte, 32), pub, []byte("ciphertext"),
+		identity.NewKey(pub), identity.NewKey(pub), pbSerializer.SignalMessage,
+	)
+	if err != nil {
+		t.Fatalf("Failed to create signal message: %v", err)
+	}
+	return msg.Serialize()
+}
+
+func TestChatWorkersSerializeSenderDecryption(t *testing.T) {
+	const messagesPerChat = 20
+	ownID := types.NewADJID("9999", 0, 1)
+	alice := types.NewADJID("1111", 0, 0)
+	bob := types.NewADJID("2222", 0, 0)
+	group := types.NewJID("123456-789", types.GroupServer)
+	bobStarted := make(chan struct{})
+	var bobOnce sync.Once
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	sessions := &sessionAccessRecorder{
+		inFlight: make(map[string]int),
+		calls:    make(map[string]int),
+		delay: func(address string, call int) {
+			switch address {
+			case alice.SignalAddress().String():
+				if call == 1 {
+					// Other senders must still be decrypted in parallel
+					select {
+					case <-bobStarted:
+					case <-ctx.Done():
+					}
+				} else if call%2 == 0 {
+					time.Sleep(time.Millisecond)
+				}
+			case bob.SignalAddress().String():
+				bobOnce.Do(func() { close(bobStarted) })
+			}
+		},
+	}
+	cli := NewClient(&store.Device{ID: &ownID, Sessions: sessions}, nil)
+	var workers sync.WaitGroup
+	var undecryptable int
+	var undecryptableLock sync.Mutex
+	cli.AddEventHandler(func(evt interface{}, _ *Client) {
+		if _, ok := evt.(*events.UndecryptableMessage); ok {
+			undecryptableLock.Lock()
+			undecryptable++
+			undecryptableLock.Unlock()
+		}
+	}, cli)
+	pool := cli.startChatWorkers(ctx, &workers, 8, false)
+
+	ciphertext := testSignalMessage(t)
+	message := func(id string, attrs waBinary.Attrs) *incomingNode {
+		attrs["id"] = id
+		attrs["t"] = strconv.FormatInt(time.Now().Unix(), 10)
+		return &incomingNode{node: &waBinary.Node{
+			Tag:     "message",
+			Attrs:   attrs,
+			Content: []waBinary.Node{{Tag: "enc", Attrs: waBinary.Attrs{"type": "msg", "v": "2"}, Content: ciphertext}},
+		}}
+	}
+	// Alice sends messages both in a DM and in a group, so the same session is used from two workers
+	for i := 0; i < messagesPerChat; i++ {
+		pool.enqueue(ctx, message(fmt.Sprintf("dm-%d", i), waBinary.Attrs{"from": alice}))
+		pool.enqueue(ctx, message(fmt.Sprintf("group-%d", i), waBinary.Attrs{"from": group, "participant": alice}))
+		pool.enqueue(ctx, message(fmt.Sprintf("bob-%d", i), waBinary.Attrs{"from": bob}))
+	}
+	if !pool.wait(ctx) {
+		t.Fatal("Timed out waiting for messages to be handled (senders aren't decrypted in parallel?)")
+	}
+
+	sessions.lock.Lock()
+	defer sessions.lock.Unlock()
+	if len(sessions.overlaps) > 0 {
+		t.Errorf("Session of %s was accessed concurrently %d times", sessions.overlaps[0], len(sessions.overlaps))
+	}
+	if calls := sessions.calls[alice.SignalAddress().String()]; calls != messagesPerChat*2 {
+		t.Errorf("Expected %d decryption attempts for alice, got %d", messagesPerChat*2, calls)
+	}
+	undecryptableLock.Lock()
+	defer undecryptableLock.Unlock()
+	if undecryptable != messagesPerChat*3 {
+		t.Errorf("Expected %d undecryptable message events, got %d", messagesPerChat*3, undecryptable)
+	}
+	cancel()
+	workers.Wait()
+}
@@ -13,13 +13,18 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"runtime/debug"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/gorilla/websocket"
+
 	"github.com/pfthink/whatsmeow/appstate"
 	waBinary "github.com/pfthink/whatsmeow/binary"
 	waProto "github.com/pfthink/whatsmeow/binary/proto"
@@ -29,17 +34,29 @@ import (
 	"github.com/pfthink/whatsmeow/types/events"
 	"github.com/pfthink/whatsmeow/util/keys"
 	waLog "github.com/pfthink/whatsmeow/util/log"
+	"github.com/pfthink/whatsmeow/util/randutil"
 )
 
 // EventHandler is a function that can handle events from WhatsApp.
 type EventHandler func(evt interface{}, newCli *Client)
-type nodeHandler func(node *waBinary.Node)
+
+// EventHandlerWithMeta is like EventHandler, but it also receives metadata about the stanza that
+// caused the event. The meta is nil for events that didn't come from a specific stanza.
+type EventHandlerWithMeta func(evt interface{}, meta *events.EventMeta, newCli *Client)
+type nodeHandler func(node *waBinary.Node, meta *events.EventMeta)
+
+// incomingNode is a received node waiting in the handler queue.
+type incomingNode struct {
+	node *waBinary.Node
+	meta *events.EventMeta
+}
 
 var nextHandlerID uint32
 
 type wrappedEventHandler struct {
-	fn EventHandler
-	id uint32
+	fn     EventHandler
+	metaFn EventHandlerWithMeta
+	id     uint32
 }
 
 // Client contains everything necessary to connect to and interact with the WhatsApp web API.
@@ -52,6 +69,13 @@ type Client struct {
 	socket     *socket.NoiseSocket
 	socketLock sync.RWMutex
 	socketWait chan struct{}
+	// Closed by Disconnect to stop Connect calls that are waiting to retry. Protected by socketLock.
+	connectCanceled chan struct{}
+
+	// The goroutines tied to the current connection (keepalive and node handler loops).
+	// A new wait group is created for each connection so that Disconnect can wait for the old ones.
+	connGoroutines   *sync.WaitGroup
+	activeGoroutines int32
 
 	isLoggedIn            uint32
 	expectedDisconnectVal uint32
@@ -59,17 +83,91 @@ type Client struct {
 	LastSuccessfulConnect time.Time
 	AutoReconnectErrors   int
 
+	connectTiming     connectTiming
+	connectTimingLock sync.Mutex
+
+	// MaxConnectRetries is the number of times Connect will retry connecting if the websocket
+	// connection or noise handshake fails with a transient network error. Other errors are returned immediately.
+	// Calling Disconnect while Connect is waiting to retry makes Connect return ErrConnectCanceled.
+	MaxConnectRetries int
+
 	sendActiveReceipts uint32
 
+	// SendDeliveryReceipts controls whether delivery receipts (the "received" double check marks) are sent
+	// automatically for incoming messages. This is true by default. Setting it to false doesn't affect read
+	// receipts (see MarkRead), and the stanza acks that the server requires are always sent regardless.
+	SendDeliveryReceipts bool
+
+	// ReadReceiptBatchWindow can be set to coalesce MarkRead calls for the same chat that happen within
+	// the given duration into a single receipt. Zero (the default) sends read receipts immediately.
+	ReadReceiptBatchWindow time.Duration
+	readReceiptBatches     map[readReceiptBatchKey]*readReceiptBatch
+	readReceiptBatchesLock sync.Mutex
+
 	// EmitAppStateEventsOnFullSync can be set to true if you want to get app state events emitted
 	// even when re-syncing the whole state.
 	EmitAppStateEventsOnFullSync bool
 
+	// RequestAppStateOnPair can be set to true to fetch all app state collections (contacts, chat settings, etc.)
+	// as soon as the phone shares the app state keys after a successful pairing, and to emit an
+	// events.AppStateSyncProgress for each collection. The app state can't be decrypted before the keys arrive.
+	RequestAppStateOnPair bool
+	appStateOnPairPending uint32
+
+	// AppStateCollections can be set to only sync some app state collections automatically (e.g. only
+	// appstate.WAPatchCriticalUnblockLow for contacts). If nil, all collections in appstate.AllPatchNames are synced.
+	// Collections can still be fetched manually with FetchAppState regardless of this option.
+	AppStateCollections []appstate.WAPatchName
+
+	// AppStateSyncKeyRetention can be set to delete old app state sync keys from the store after an app state
+	// snapshot has been applied. Keys older than the retention are deleted, except for the few most recent keys
+	// and any keys used by the current state of a collection. Keys are only deleted once every collection in
+	// AppStateCollections has been synced from a snapshot since the client was created, and only if the
+	// key store implements store.AppStateSyncKeyPruner. Zero (the default) never deletes keys.
+	//
+	// If AppStateCollections is set, collections outside of it aren't waited for, so fetching one of them
+	// manually later may need old keys to be requested from the primary device again.
+	AppStateSyncKeyRetention time.Duration
+
+	// DeviceListCacheTTL is the maximum time to use cached device lists of users when sending messages.
+	// Device lists are re-fetched if they were fetched longer ago than this. Zero (the default) means the cache
+	// never expires, as it's updated by device list notifications from the server anyway.
+	// Use RefreshDeviceList to force refresh the device list of a specific user.
+	DeviceListCacheTTL time.Duration
+
+	// AutoResyncOnAccountSync can be set to true to automatically do a full app state resync when the server
+	// says that the account needs to be synced (e.g. after relinking). An events.AccountSyncRequired is emitted either way.
+	AutoResyncOnAccountSync bool
+
+	// OfflineMessageWorkers can be set to process messages that were queued on the server while the client was
+	// offline with multiple goroutines. Messages in the same chat are always processed in order, and all queued
+	// messages are processed before any other incoming node. Zero or one means offline messages are processed
+	// serially like everything else. Changes take effect on the next connection.
+	//
+	// When enabled, event handlers are called concurrently from multiple goroutines for messages in different
+	// chats, so they must be safe for concurrent use. Messages from the same sender device are still decrypted
+	// (and their events dispatched) one at a time, as they share the same encryption session state.
+	OfflineMessageWorkers int
+
+	// MessageWorkers is like OfflineMessageWorkers, but applies to all incoming messages instead of only offline ones.
+	// Messages (and their events) in the same chat are always handled in the order they were received, while
+	// different chats are handled in parallel. Other nodes like receipts are still handled after all messages
+	// received before them. If both options are set, this one takes precedence. The same concurrency notes as
+	// for OfflineMessageWorkers apply.
+	MessageWorkers int
+
+	// SkipOfflineMessageEvents can be set to true to not emit events for messages that were queued on the
+	// server while the client was offline. The messages are still decrypted (to keep encryption sessions
+	// in sync), acknowledged and marked as delivered, so the offline queue is cleared like usual.
+	SkipOfflineMessageEvents bool
+
 	appStateProc     *appstate.Processor
 	appStateSyncLock sync.Mutex
+	appStateKeyUsage map[appstate.WAPatchName]*appStateKeyUsage
 
 	historySyncNotifications  chan *waProto.HistorySyncNotification
 	historySyncHandlerStarted uint32
+	historySyncChunks         map[waProto.HistorySync_HistorySyncHistorySyncType]int
 
 	uploadPreKeysLock sync.Mutex
 	lastPreKeyUpload  time.Time
@@ -81,13 +179,16 @@ type Client struct {
 	responseWaitersLock sync.Mutex
 
 	nodeHandlers      map[string]nodeHandler
-	handlerQueue      chan *waBinary.Node
+	handlerQueue      chan *incomingNode
 	eventHandlers     []wrappedEventHandler
 	eventHandlersLock sync.RWMutex
 
 	messageRetries     map[string]int
 	messageRetriesLock sync.Mutex
 
+	senderDecryptLocks     map[types.JID]*senderDecryptLock
+	senderDecryptLocksLock sync.Mutex
+
 	appStateKeyRequests     map[string]time.Time
 	appStateKeyRequestsLock sync.RWMutex
 
@@ -97,13 +198,14 @@ type Client struct {
 
 	groupParticipantsCache     map[types.JID][]types.JID
 	groupParticipantsCacheLock sync.Mutex
-	userDevicesCache           map[types.JID][]types.JID
+	userDevicesCache           map[types.JID]deviceCache
 	userDevicesCacheLock       sync.Mutex
 
-	recentMessagesMap  map[recentMessageKey]*waProto.Message
-	recentMessagesList [recentMessagesSize]recentMessageKey
-	recentMessagesPtr  int
-	recentMessagesLock sync.RWMutex
+	recentMessagesMap     map[recentMessageKey]*waProto.Message
+	recentMessagesList    [recentMessagesSize]recentMessageKey
+	recentMessagesPtr     int
+	recentMessagesLock    sync.RWMutex
+	recentMessageReceipts map[recentMessageKey]map[types.JID]*ParticipantReceiptInfo
 
 	sessionRecreateHistory     map[types.JID]time.Time
 	sessionRecreateHistoryLock sync.Mutex
@@ -119,10 +221,32 @@ type Client struct {
 	// If false, decrypting a message from untrusted devices will fail.
 	AutoTrustIdentity bool
 
+	// MigrateContactOnNumberChange can be set to true to copy the stored contact info (names) of a user
+	// to their new JID when they change their phone number. An events.PhoneNumberChange is emitted either way.
+	MigrateContactOnNumberChange bool
+
+	// OnFrameSent is called after each frame has been written to the websocket, e.g. for audit logging.
+	// The data is the frame as it was sent (encrypted once the noise handshake is done), and node is the node
+	// that the frame contained, or nil for handshake frames. The connection header (socket.WAConnHeader) that
+	// is sent before the first handshake frame is reported as a separate call with a nil node. The only bytes
+	// that aren't reported are the 3-byte length prefixes of frames and the websocket protocol framing itself.
+	// The callback is called synchronously, so it shouldn't block.
+	OnFrameSent func(data []byte, node *waBinary.Node)
+	// OnFrameReceived is the same as OnFrameSent, but for frames received from the websocket.
+	// The node is nil for handshake frames and frames that failed to be decrypted or decoded.
+	OnFrameReceived func(data []byte, node *waBinary.Node)
+
+	// EmitSenderKeyEvents can be set to true to get an events.SenderKeyDistribution whenever a group
+	// sender key distribution message is processed. This is mostly useful for debugging group decryption issues.
+	EmitSenderKeyEvents bool
+
 	uniqueID  string
 	idCounter uint32
 
+	random io.Reader
+
 	proxy   socket.Proxy
+	dialer  socket.Dialer
 	http    *http.Client
 	BizType string
 }
@@ -165,22 +289,29 @@ func NewClient(deviceStore *store.Device, log waLog.Logger) *Client {
 		responseWaiters: make(map[string]chan<- *waBinary.Node),
 		eventHandlers:   make([]wrappedEventHandler, 0, 1),
 		messageRetries:  make(map[string]int),
-		handlerQueue:    make(chan *waBinary.Node, handlerQueueSize),
+		handlerQueue:    make(chan *incomingNode, handlerQueueSize),
 		appStateProc:    appstate.NewProcessor(deviceStore, log.Sub("AppState")),
 		socketWait:      make(chan struct{}),
 
 		historySyncNotifications: make(chan *waProto.HistorySyncNotification, 32),
+		historySyncChunks:        make(map[waProto.HistorySync_HistorySyncHistorySyncType]int),
 
 		groupParticipantsCache: make(map[types.JID][]types.JID),
-		userDevicesCache:       make(map[types.JID][]types.JID),
+		userDevicesCache:       make(map[types.JID]deviceCache),
 
 		recentMessagesMap:      make(map[recentMessageKey]*waProto.Message, recentMessagesSize),
+		recentMessageReceipts:  make(map[recentMessageKey]map[types.JID]*ParticipantReceiptInfo),
+		readReceiptBatches:     make(map[readReceiptBatchKey]*readReceiptBatch),
 		sessionRecreateHistory: make(map[types.JID]time.Time),
+		senderDecryptLocks:     make(map[types.JID]*senderDecryptLock),
 		GetMessageForRetry:     func(requester, to types.JID, id types.MessageID) *waProto.Message { return nil },
 		appStateKeyRequests:    make(map[string]time.Time),
+		appStateKeyUsage:       make(map[appstate.WAPatchName]*appStateKeyUsage),
 
-		EnableAutoReconnect: true,
-		AutoTrustIdentity:   true,
+		EnableAutoReconnect:  true,
+		MaxConnectRetries:    2,
+		AutoTrustIdentity:    true,
+		SendDeliveryReceipts: true,
 	}
 	cli.nodeHandlers = map[string]nodeHandler{
 		"message":      cli.handleEncryptedMessage,
@@ -199,6 +330,20 @@ func NewClient(deviceStore *store.Device, log waLog.Logger) *Client {
 	return cli
 }
 
+// SetRandomSource sets the randomness source used for generating message IDs and the ephemeral
+// keys of the noise handshake. Keys in the device store are generated by the store itself, e.g.
+// see sqlstore.Container.SetRandomSource.
+//
+// By default, crypto/rand is used. Passing nil resets the source to the default. Sources from
+// math/rand are rejected with randutil.ErrWeakSource unless running inside a test binary.
+func (cli *Client) SetRandomSource(random io.Reader) error {
+	if err := randutil.Validate(random); err != nil {
+		return err
+	}
+	cli.random = random
+	return nil
+}
+
 // SetProxyAddress is a helper method that parses a URL string and calls SetProxy.
 //
 // Returns an error if url.Parse fails to parse the given address.
@@ -234,6 +379,19 @@ func (cli *Client) SetProxy(proxy socket.Proxy) {
 	cli.http.Transport.(*http.Transport).Proxy = proxy
 }
 
+// SetDialer sets the function used to open the underlying TCP connection of the WhatsApp web websocket.
+// This can be used for things like custom DNS resolution or happy eyeballs tuning. Media uploads and downloads
+// don't use the dialer.
+//
+// Must be called before Connect() to take effect. The proxy set with SetProxy is still used on top of the
+// dialer, so if the dialer should handle everything, the proxy should be set to nil. A nil dialer means the
+// default net.Dialer will be used:
+//   cli.SetProxy(nil)
+//   cli.SetDialer((&net.Dialer{Resolver: myResolver}).DialContext)
+func (cli *Client) SetDialer(dialer socket.Dialer) {
+	cli.dialer = dialer
+}
+
 func (cli *Client) getSocketWaitChan() <-chan struct{} {
 	cli.socketLock.RLock()
 	ch := cli.socketWait
@@ -270,6 +428,62 @@ func (cli *Client) WaitForConnection(timeout time.Duration) bool {
 func (cli *Client) Connect() error {
 	cli.socketLock.Lock()
 	defer cli.socketLock.Unlock()
+	return cli.unlockedConnect()
+}
+
+// Reconnect closes the current websocket connection (if any) and connects again.
+//
+// The goroutines of the old connection are waited for before connecting again, like in DisconnectAndWait,
+// so this must not be called from an event handler without starting a new goroutine.
+// If something else connects the client in the meantime, ErrAlreadyConnected is returned, so
+// there is never more than one active connection. Like Disconnect, closing the old connection
+// will not emit a Disconnected event.
+func (cli *Client) Reconnect() error {
+	cli.socketLock.Lock()
+	oldSocket, oldGoroutines := cli.socket, cli.connGoroutines
+	cli.unlockedDisconnect()
+	cli.socketLock.Unlock()
+	// The old goroutines may need the socket lock to exit, so they can't be waited for while holding it.
+	waitForConnGoroutines(oldSocket, oldGoroutines)
+
+	cli.socketLock.Lock()
+	defer cli.socketLock.Unlock()
+	return cli.unlockedConnect()
+}
+
+func waitForConnGoroutines(ns *socket.NoiseSocket, wg *sync.WaitGroup) {
+	if ns != nil {
+		ns.Wait()
+	}
+	if wg != nil {
+		wg.Wait()
+	}
+}
+
+// goConn runs the given function in a goroutine that is tracked in the given connection's wait group.
+//
+// The wait group must be the one the connection was started with rather than cli.connGoroutines,
+// which is replaced (under socketLock) when reconnecting.
+func (cli *Client) goConn(wg *sync.WaitGroup, fn func()) {
+	wg.Add(1)
+	atomic.AddInt32(&cli.activeGoroutines, 1)
+	go func() {
+		defer func() {
+			atomic.AddInt32(&cli.activeGoroutines, -1)
+			wg.Done()
+		}()
+		fn()
+	}()
+}
+
+// ActiveGoroutines returns the number of goroutines the client is currently running for connections,
+// i.e. the keepalive and incoming node handler loops. This is mostly meant for checking for leaks in tests:
+// after DisconnectAndWait returns, it should always be zero.
+func (cli *Client) ActiveGoroutines() int {
+	return int(atomic.LoadInt32(&cli.activeGoroutines))
+}
+
+func (cli *Client) unlockedConnect() error {
 	if cli.socket != nil {
 		if !cli.socket.IsConnected() {
 			cli.unlockedDisconnect()
@@ -279,19 +493,96 @@ func (cli *Client) Connect() error {
 	}
 
 	cli.resetExpectedDisconnect()
+	for attempt := 1; ; attempt++ {
+		err := cli.connectOnce()
+		if err == nil {
+			break
+		} else if attempt > cli.MaxConnectRetries || !isTransientConnectError(err) {
+			return err
+		}
+		retryDelay := time.Duration(attempt) * 2 * time.Second
+		cli.Log.Warnf("Transient error connecting to WhatsApp (attempt %d, retrying in %v): %v", attempt, retryDelay, err)
+		if err = cli.waitConnectRetry(retryDelay); err != nil {
+			return err
+		}
+	}
+	ctx := cli.socket.Context()
+	wg := &sync.WaitGroup{}
+	cli.connGoroutines = wg
+	cli.goConn(wg, func() { cli.keepAliveLoop(ctx) })
+	cli.goConn(wg, func() { cli.handlerQueueLoop(ctx, wg) })
+	return nil
+}
+
+// waitConnectRetry waits for the given delay without holding the socket lock, so that Disconnect and other
+// calls aren't blocked during the backoff. The lock must be held when calling this and is held again after it returns.
+func (cli *Client) waitConnectRetry(delay time.Duration) error {
+	if cli.connectCanceled == nil {
+		cli.connectCanceled = make(chan struct{})
+	}
+	canceled := cli.connectCanceled
+	cli.socketLock.Unlock()
+	timer := time.NewTimer(delay)
+	select {
+	case <-timer.C:
+	case <-canceled:
+		timer.Stop()
+	}
+	cli.socketLock.Lock()
+	select {
+	case <-canceled:
+		return ErrConnectCanceled
+	default:
+	}
+	if cli.socket != nil {
+		// Something else connected while the lock was released
+		return ErrAlreadyConnected
+	}
+	return nil
+}
+
+func (cli *Client) connectOnce() error {
 	fs := socket.NewFrameSocket(cli.Log.Sub("Socket"), socket.WAConnHeader, cli.proxy)
+	fs.Dialer = cli.dialer
+	start := time.Now()
 	if err := fs.Connect(); err != nil {
 		fs.Close(0)
 		return err
-	} else if err = cli.doHandshake(fs, *keys.NewKeyPair()); err != nil {
+	}
+	// The handshake end time is filled by doHandshake, as the success node may arrive before it returns
+	cli.connectTimingLock.Lock()
+	cli.connectTiming = connectTiming{start: start, dialed: time.Now()}
+	cli.connectTimingLock.Unlock()
+	if err := cli.doHandshake(fs, *keys.NewKeyPairFromReader(randutil.OrDefault(cli.random))); err != nil {
 		fs.Close(0)
 		return fmt.Errorf("noise handshake failed: %w", err)
 	}
-	go cli.keepAliveLoop(cli.socket.Context())
-	go cli.handlerQueueLoop(cli.socket.Context())
 	return nil
 }
 
+// isTransientConnectError checks if the given error from connecting is likely to go away by itself,
+// like network timeouts and connection resets. Rejections by the server (e.g. a bad websocket
+// handshake response or invalid noise handshake data) are not transient.
+func isTransientConnectError(err error) bool {
+	var netErr net.Error
+	var closeErr *websocket.CloseError
+	switch {
+	case errors.Is(err, ErrHandshakeTimeout),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNABORTED):
+		return true
+	case errors.As(err, &closeErr):
+		return closeErr.Code == websocket.CloseAbnormalClosure
+	case errors.As(err, &netErr):
+		return netErr.Timeout()
+	default:
+		return false
+	}
+}
+
 // IsLoggedIn returns true after the client is successfully connected and authenticated on WhatsApp.
 func (cli *Client) IsLoggedIn() bool {
 	return atomic.LoadUint32(&cli.isLoggedIn) == 1
@@ -343,6 +634,9 @@ func (cli *Client) autoReconnect() {
 		if errors.Is(err, ErrAlreadyConnected) {
 			cli.Log.Debugf("Connect() said we're already connected after autoreconnect sleep")
 			return
+		} else if errors.Is(err, ErrConnectCanceled) {
+			cli.Log.Debugf("Connect() was canceled by Disconnect() during autoreconnect")
+			return
 		} else if err != nil {
 			cli.Log.Errorf("Error reconnecting after autoreconnect sleep: %v", err)
 		} else {
@@ -364,13 +658,35 @@ func (cli *Client) IsConnected() bool {
 //
 // This will not emit any events, the Disconnected event is only used when the
 // connection is closed by the server or a network error.
+//
+// Disconnect doesn't wait for the goroutines of the connection to exit, so it's safe to call from
+// event handlers. Use DisconnectAndWait if you need to know when everything has stopped.
 func (cli *Client) Disconnect() {
-	if cli.socket == nil {
-		return
-	}
 	cli.socketLock.Lock()
+	cli.cancelConnectRetries()
+	cli.unlockedDisconnect()
+	cli.socketLock.Unlock()
+}
+
+// DisconnectAndWait disconnects like Disconnect, then blocks until all the goroutines of the connection
+// (websocket reading, keepalive and the node handler loop, see ActiveGoroutines) have exited.
+//
+// Event handlers are called from the node handler loop, so this must not be called from an event handler,
+// as it would end up waiting for itself.
+func (cli *Client) DisconnectAndWait() {
+	cli.socketLock.Lock()
+	oldSocket, oldGoroutines := cli.socket, cli.connGoroutines
+	cli.cancelConnectRetries()
 	cli.unlockedDisconnect()
 	cli.socketLock.Unlock()
+	waitForConnGoroutines(oldSocket, oldGoroutines)
+}
+
+func (cli *Client) cancelConnectRetries() {
+	if cli.connectCanceled != nil {
+		close(cli.connectCanceled)
+		cli.connectCanceled = nil
+	}
 }
 
 // Disconnect closes the websocket connection.
@@ -448,11 +764,39 @@ func (cli *Client) Logout() error {
 func (cli *Client) AddEventHandler(handler EventHandler, newCli *Client) uint32 {
 	nextID := atomic.AddUint32(&nextHandlerID, 1)
 	newCli.eventHandlersLock.Lock()
-	newCli.eventHandlers = append(newCli.eventHandlers, wrappedEventHandler{handler, nextID})
+	newCli.eventHandlers = append(newCli.eventHandlers, wrappedEventHandler{fn: handler, id: nextID})
 	newCli.eventHandlersLock.Unlock()
 	return nextID
 }
 
+// AddEventHandlerWithMeta registers a new function to receive all events emitted by this client
+// along with the metadata of the stanza that produced each event (see events.EventMeta).
+//
+// The returned ID can be passed to RemoveEventHandler like the IDs returned by AddEventHandler.
+func (cli *Client) AddEventHandlerWithMeta(handler EventHandlerWithMeta, newCli *Client) uint32 {
+	nextID := atomic.AddUint32(&nextHandlerID, 1)
+	newCli.eventHandlersLock.Lock()
+	newCli.eventHandlers = append(newCli.eventHandlers, wrappedEventHandler{metaFn: handler, id: nextID})
+	newCli.eventHandlersLock.Unlock()
+	return nextID
+}
+
+// AddTypedHandler registers a function that only receives events of the given type from the client.
+//
+// The type parameter is the event struct type, for example:
+//   whatsmeow.AddTypedHandler(cli, func(evt *events.Message) {
+//       fmt.Println("Received a message!")
+//   })
+//
+// The returned ID can be passed to RemoveEventHandler like the IDs returned by AddEventHandler.
+func AddTypedHandler[T any](cli *Client, handler func(evt *T)) uint32 {
+	return cli.AddEventHandler(func(rawEvt interface{}, _ *Client) {
+		if evt, ok := rawEvt.(*T); ok {
+			handler(evt)
+		}
+	}, cli)
+}
+
 // RemoveEventHandler removes a previously registered event handler function.
 // If the function with the given ID is found, this returns true.
 //
@@ -471,12 +815,14 @@ func (cli *Client) RemoveEventHandler(id uint32) bool {
 		if cli.eventHandlers[index].id == id {
 			if index == 0 {
 				cli.eventHandlers[0].fn = nil
+				cli.eventHandlers[0].metaFn = nil
 				cli.eventHandlers = cli.eventHandlers[1:]
 				return true
 			} else if index < len(cli.eventHandlers)-1 {
 				copy(cli.eventHandlers[index:], cli.eventHandlers[index+1:])
 			}
 			cli.eventHandlers[len(cli.eventHandlers)-1].fn = nil
+			cli.eventHandlers[len(cli.eventHandlers)-1].metaFn = nil
 			cli.eventHandlers = cli.eventHandlers[:len(cli.eventHandlers)-1]
 			return true
 		}
@@ -491,19 +837,39 @@ func (cli *Client) RemoveEventHandlers() {
 	cli.eventHandlersLock.Unlock()
 }
 
-func (cli *Client) handleFrame(data []byte) {
+func (cli *Client) frameSent(data []byte, node *waBinary.Node) {
+	if cli.OnFrameSent != nil {
+		cli.OnFrameSent(data, node)
+	}
+}
+
+func (cli *Client) frameReceived(data []byte, node *waBinary.Node) {
+	if cli.OnFrameReceived != nil {
+		cli.OnFrameReceived(data, node)
+	}
+}
+
+func (cli *Client) handleFrame(ciphertext, data []byte) {
+	if data == nil {
+		// The noise socket already logged the decryption error
+		cli.frameReceived(ciphertext, nil)
+		return
+	}
 	decompressed, err := waBinary.Unpack(data)
 	if err != nil {
+		cli.frameReceived(ciphertext, nil)
 		cli.Log.Warnf("Failed to decompress frame: %v", err)
 		cli.Log.Debugf("Errored frame hex: %s", hex.EncodeToString(data))
 		return
 	}
 	node, err := waBinary.Unmarshal(decompressed)
 	if err != nil {
+		cli.frameReceived(ciphertext, nil)
 		cli.Log.Warnf("Failed to decode node in frame: %v", err)
 		cli.Log.Debugf("Errored frame hex: %s", hex.EncodeToString(decompressed))
 		return
 	}
+	cli.frameReceived(ciphertext, node)
 	cli.recvLog.Debugf("%s", node.XMLString())
 	if node.Tag == "xmlstreamend" {
 		if !cli.isExpectedDisconnect() {
@@ -513,12 +879,14 @@ func (cli *Client) handleFrame(data []byte) {
 	} else if cli.receiveResponse(node) {
 		// handled
 	} else if _, ok := cli.nodeHandlers[node.Tag]; ok {
+		// The metadata is created here so that the receive time doesn't include the time spent in the queue
+		item := &incomingNode{node: node, meta: eventMetaFromNode(node)}
 		select {
-		case cli.handlerQueue <- node:
+		case cli.handlerQueue <- item:
 		default:
 			cli.Log.Warnf("Handler queue is full, message ordering is no longer guaranteed")
 			go func() {
-				cli.handlerQueue <- node
+				cli.handlerQueue <- item
 			}()
 		}
 	} else {
@@ -526,11 +894,24 @@ func (cli *Client) handleFrame(data []byte) {
 	}
 }
 
-func (cli *Client) handlerQueueLoop(ctx context.Context) {
+func (cli *Client) handlerQueueLoop(ctx context.Context, wg *sync.WaitGroup) {
+	var workerPool *chatWorkerPool
+	if cli.MessageWorkers > 1 {
+		workerPool = cli.startChatWorkers(ctx, wg, cli.MessageWorkers, false)
+	} else if cli.OfflineMessageWorkers > 1 {
+		workerPool = cli.startChatWorkers(ctx, wg, cli.OfflineMessageWorkers, true)
+	}
 	for {
 		select {
-		case node := <-cli.handlerQueue:
-			cli.nodeHandlers[node.Tag](node)
+		case item := <-cli.handlerQueue:
+			if workerPool != nil {
+				if workerPool.enqueue(ctx, item) {
+					continue
+				} else if !workerPool.wait(ctx) {
+					return
+				}
+			}
+			cli.nodeHandlers[item.node.Tag](item.node, item.meta)
 		case <-ctx.Done():
 			return
 		}
@@ -551,7 +932,11 @@ func (cli *Client) sendNodeAndGetData(node waBinary.Node) ([]byte, error) {
 	}
 
 	cli.sendLog.Debugf("%s", node.XMLString())
-	return payload, sock.SendFrame(payload)
+	ciphertext, err := sock.SendFrameAndGetCiphertext(payload)
+	if err == nil {
+		cli.frameSent(ciphertext, &node)
+	}
+	return payload, err
 }
 
 func (cli *Client) sendNode(node waBinary.Node) error {
@@ -560,6 +945,15 @@ func (cli *Client) sendNode(node waBinary.Node) error {
 }
 
 func (cli *Client) dispatchEvent(evt interface{}) {
+	cli.dispatchEventWithMeta(evt, nil)
+}
+
+func eventMetaFromNode(node *waBinary.Node) *events.EventMeta {
+	id, _ := node.Attrs["id"].(string)
+	return &events.EventMeta{SourceID: id, ReceivedAt: time.Now()}
+}
+
+func (cli *Client) dispatchEventWithMeta(evt interface{}, meta *events.EventMeta) {
 	cli.eventHandlersLock.RLock()
 	defer func() {
 		cli.eventHandlersLock.RUnlock()
@@ -569,7 +963,11 @@ func (cli *Client) dispatchEvent(evt interface{}) {
 		}
 	}()
 	for _, handler := range cli.eventHandlers {
-		handler.fn(evt, cli)
+		if handler.metaFn != nil {
+			handler.metaFn(evt, meta, cli)
+		} else {
+			handler.fn(evt, cli)
+		}
 	}
 }
 
@@ -0,0 +1,167 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	waBinary "github.com/pfthink/whatsmeow/binary"
+	"github.com/pfthink/whatsmeow/socket"
+	"github.com/pfthink/whatsmeow/store"
+	"github.com/pfthink/whatsmeow/types"
+	"github.com/pfthink/whatsmeow/types/events"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransientConnectError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{"handshake timeout", fmt.Errorf("noise handshake failed: %w", ErrHandshakeTimeout), true},
+		{"EOF", fmt.Errorf("noise handshake failed: %w", io.EOF), true},
+		{"unexpected EOF", io.ErrUnexpectedEOF, true},
+		{"connection reset", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{"connection refused", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{"network timeout", &net.OpError{Op: "dial", Err: timeoutError{}}, true},
+		{"abnormal close", &websocket.CloseError{Code: websocket.CloseAbnormalClosure}, true},
+		{"normal close", &websocket.CloseError{Code: websocket.CloseNormalClosure}, false},
+		{"bad handshake", websocket.ErrBadHandshake, false},
+		{"other error", errors.New("failed to verify server certificate"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if transient := isTransientConnectError(test.err); transient != test.transient {
+				t.Errorf("isTransientConnectError(%v) = %t, expected %t", test.err, transient, test.transient)
+			}
+		})
+	}
+}
+
+func TestDisconnectCancelsConnectRetry(t *testing.T) {
+	cli := &Client{}
+	errs := make(chan error, 1)
+	go func() {
+		cli.socketLock.Lock()
+		errs <- cli.waitConnectRetry(time.Minute)
+		cli.socketLock.Unlock()
+	}()
+	for waiting := false; !waiting; {
+		time.Sleep(time.Millisecond)
+		cli.socketLock.RLock()
+		waiting = cli.connectCanceled != nil
+		cli.socketLock.RUnlock()
+	}
+	// The lock must not be held during the backoff
+	if cli.IsConnected() {
+		t.Fatal("Client without a socket is connected")
+	}
+	cli.Disconnect()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrConnectCanceled) {
+			t.Fatalf("Expected ErrConnectCanceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Disconnect didn't cancel the connect retry")
+	}
+}
+
+// connectTestSocket connects the client to a local websocket server that reads and ignores everything,
+// and starts the node handler loop like a normal connection would.
+func connectTestSocket(t *testing.T, cli *Client) {
+	// The client sends the WhatsApp web origin, so the origin check has to be disabled
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err = conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	fs := socket.NewFrameSocket(cli.Log, socket.WAConnHeader, nil)
+	fs.URL = "ws" + strings.TrimPrefix(server.URL, "http")
+	if err := fs.Connect(); err != nil {
+		t.Fatalf("Failed to connect to test server: %v", err)
+	}
+	nh := socket.NewNoiseHandshake()
+	nh.Start(socket.NoiseStartPattern, fs.Header)
+	ns, err := nh.Finish(fs, cli.handleFrame, cli.onDisconnect)
+	if err != nil {
+		t.Fatalf("Failed to create noise socket: %v", err)
+	}
+	cli.socketLock.Lock()
+	cli.socket = ns
+	wg := &sync.WaitGroup{}
+	cli.connGoroutines = wg
+	ctx := ns.Context()
+	cli.goConn(wg, func() { cli.handlerQueueLoop(ctx, wg) })
+	cli.socketLock.Unlock()
+}
+
+func TestDisconnectFromEventHandler(t *testing.T) {
+	cli := NewClient(&store.Device{}, nil)
+	connectTestSocket(t, cli)
+	disconnected := make(chan struct{})
+	cli.AddEventHandler(func(evt interface{}, cli *Client) {
+		if _, ok := evt.(*events.Presence); ok {
+			cli.Disconnect()
+			close(disconnected)
+		}
+	}, cli)
+	cli.handlerQueue <- &incomingNode{node: &waBinary.Node{
+		Tag:   "presence",
+		Attrs: waBinary.Attrs{"from": types.NewJID("1111", types.DefaultUserServer)},
+	}}
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Disconnect blocked when called from an event handler")
+	}
+	if cli.IsConnected() {
+		t.Error("Client is still connected after Disconnect")
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		cli.DisconnectAndWait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("DisconnectAndWait didn't return after the connection was closed")
+	}
+	if count := cli.ActiveGoroutines(); count != 0 {
+		t.Errorf("Expected no active goroutines after DisconnectAndWait, got %d", count)
+	}
+}
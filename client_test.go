@@ -19,7 +19,7 @@ import (
 	waLog "github.com/pfthink/whatsmeow/util/log"
 )
 
-func eventHandler(evt interface{}) {
+func eventHandler(evt interface{}, cli *whatsmeow.Client) {
 	switch v := evt.(type) {
 	case *events.Message:
 		fmt.Println("Received a message!", v.Message.GetConversation())
@@ -40,7 +40,7 @@ func Example() {
 	}
 	clientLog := waLog.Stdout("Client", "DEBUG", true)
 	client := whatsmeow.NewClient(deviceStore, clientLog)
-	client.AddEventHandler(eventHandler)
+	client.AddEventHandler(eventHandler, client)
 
 	if client.Store.ID == nil {
 		// No ID stored, new login
@@ -68,7 +68,7 @@ func Example() {
 	}
 
 	// Listen to Ctrl+C (you can also do something else that prevents the program from exiting)
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	<-c
 
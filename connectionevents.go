@@ -15,7 +15,7 @@ import (
 	"github.com/pfthink/whatsmeow/types/events"
 )
 
-func (cli *Client) handleStreamError(node *waBinary.Node) {
+func (cli *Client) handleStreamError(node *waBinary.Node, meta *events.EventMeta) {
 	atomic.StoreUint32(&cli.isLoggedIn, 0)
 	cli.clearResponseWaiters(node)
 	code, _ := node.Attrs["code"].(string)
@@ -25,8 +25,7 @@ func (cli *Client) handleStreamError(node *waBinary.Node) {
 	case code == "515":
 		cli.Log.Infof("Got 515 code, reconnecting...")
 		go func() {
-			cli.Disconnect()
-			err := cli.Connect()
+			err := cli.Reconnect()
 			if err != nil {
 				cli.Log.Errorf("Failed to reconnect after 515 code:", err)
 			}
@@ -34,7 +33,7 @@ func (cli *Client) handleStreamError(node *waBinary.Node) {
 	case code == "401" && conflictType == "device_removed":
 		cli.expectDisconnect()
 		cli.Log.Infof("Got device removed stream error, sending LoggedOut event and deleting session")
-		go cli.dispatchEvent(&events.LoggedOut{OnConnect: false, Reason: events.ConnectFailureLoggedOut})
+		go cli.dispatchEventWithMeta(&events.LoggedOut{OnConnect: false, Reason: events.ConnectFailureLoggedOut}, meta)
 		err := cli.Store.Delete()
 		if err != nil {
 			cli.Log.Warnf("Failed to delete store after device_removed error: %v", err)
@@ -42,47 +41,109 @@ func (cli *Client) handleStreamError(node *waBinary.Node) {
 	case conflictType == "replaced":
 		cli.expectDisconnect()
 		cli.Log.Infof("Got replaced stream error, sending StreamReplaced event")
-		go cli.dispatchEvent(&events.StreamReplaced{})
+		go cli.dispatchEventWithMeta(&events.StreamReplaced{}, meta)
 	case code == "503":
 		// This seems to happen when the server wants to restart or something.
 		// The disconnection will be emitted as an events.Disconnected and then the auto-reconnect will do its thing.
 		cli.Log.Warnf("Got 503 stream error, assuming automatic reconnect will handle it")
 	default:
 		cli.Log.Errorf("Unknown stream error: %s", node.XMLString())
-		go cli.dispatchEvent(&events.StreamError{Code: code, Raw: node})
+		go cli.dispatchEventWithMeta(&events.StreamError{Code: code, Raw: node}, meta)
 	}
 }
 
-func (cli *Client) handleIB(node *waBinary.Node) {
+func (cli *Client) handleIB(node *waBinary.Node, meta *events.EventMeta) {
 	children := node.GetChildren()
 	for _, child := range children {
 		ag := child.AttrGetter()
 		switch child.Tag {
 		case "downgrade_webclient":
-			go cli.dispatchEvent(&events.QRScannedWithoutMultidevice{})
+			go cli.dispatchEventWithMeta(&events.QRScannedWithoutMultidevice{}, meta)
 		case "offline_preview":
-			cli.dispatchEvent(&events.OfflineSyncPreview{
+			cli.dispatchEventWithMeta(&events.OfflineSyncPreview{
 				Total:          ag.Int("count"),
 				AppDataChanges: ag.Int("appdata"),
 				Messages:       ag.Int("message"),
 				Notifications:  ag.Int("notification"),
 				Receipts:       ag.Int("receipt"),
-			})
+			}, meta)
 		case "offline":
-			cli.dispatchEvent(&events.OfflineSyncCompleted{
+			cli.dispatchEventWithMeta(&events.OfflineSyncCompleted{
 				Count: ag.Int("count"),
-			})
+			}, meta)
+			if evt := cli.finishConnectTiming(); evt != nil {
+				cli.dispatchEvent(evt)
+			}
+		case "dirty":
+			go cli.handleDirtyNotification(ag.String("type"), ag.UnixTime("timestamp"), meta)
 		}
 	}
 }
 
-func (cli *Client) handleConnectFailure(node *waBinary.Node) {
+type connectTiming struct {
+	start      time.Time
+	dialed     time.Time
+	handshaked time.Time
+	loggedIn   time.Time
+}
+
+// finishConnectTiming returns the ConnectTiming event for the current connection, or nil if the event was already
+// dispatched or the connection didn't go through all the phases.
+func (cli *Client) finishConnectTiming() *events.ConnectTiming {
+	cli.connectTimingLock.Lock()
+	timing := cli.connectTiming
+	cli.connectTiming = connectTiming{}
+	cli.connectTimingLock.Unlock()
+	if timing.start.IsZero() || timing.loggedIn.IsZero() {
+		return nil
+	}
+	return &events.ConnectTiming{
+		DialMs:        timing.dialed.Sub(timing.start).Milliseconds(),
+		HandshakeMs:   timing.handshaked.Sub(timing.dialed).Milliseconds(),
+		LoginMs:       timing.loggedIn.Sub(timing.handshaked).Milliseconds(),
+		OfflineSyncMs: time.Since(timing.loggedIn).Milliseconds(),
+	}
+}
+
+func (cli *Client) handleDirtyNotification(dirtyType string, ts time.Time, meta *events.EventMeta) {
+	cli.Log.Debugf("Got dirty notification for %s (timestamp: %d)", dirtyType, ts.Unix())
+	if dirtyType == "account_sync" {
+		cli.handleAccountSyncRequired(ts, meta)
+	}
+	err := cli.MarkNotDirty(dirtyType, ts)
+	if err != nil {
+		cli.Log.Warnf("Failed to mark %s as not dirty: %v", dirtyType, err)
+		return
+	}
+	cli.dispatchEventWithMeta(&events.DirtyCleaned{Type: dirtyType, Timestamp: ts}, meta)
+}
+
+// MarkNotDirty tells the server that the given dirty state has been cleaned up.
+//
+// This is called automatically when the server sends a dirty notification, so you generally don't need to call it yourself.
+func (cli *Client) MarkNotDirty(cleanType string, ts time.Time) error {
+	_, err := cli.sendIQ(infoQuery{
+		Namespace: "urn:xmpp:whatsapp:dirty",
+		Type:      iqSet,
+		To:        types.ServerJID,
+		Content: []waBinary.Node{{
+			Tag: "clean",
+			Attrs: waBinary.Attrs{
+				"type":      cleanType,
+				"timestamp": ts.Unix(),
+			},
+		}},
+	})
+	return err
+}
+
+func (cli *Client) handleConnectFailure(node *waBinary.Node, meta *events.EventMeta) {
 	ag := node.AttrGetter()
 	reason := events.ConnectFailureReason(ag.Int("reason"))
 	cli.expectDisconnect()
 	if reason.IsLoggedOut() {
 		cli.Log.Infof("Got %s connect failure, sending LoggedOut event and deleting session", reason)
-		go cli.dispatchEvent(&events.LoggedOut{OnConnect: true, Reason: reason})
+		go cli.dispatchEventWithMeta(&events.LoggedOut{OnConnect: true, Reason: reason}, meta)
 		err := cli.Store.Delete()
 		if err != nil {
 			cli.Log.Warnf("Failed to delete store after %d failure: %v", int(reason), err)
@@ -90,22 +151,25 @@ func (cli *Client) handleConnectFailure(node *waBinary.Node) {
 	} else if reason == events.ConnectFailureTempBanned {
 		cli.Log.Warnf("Temporary ban connect failure: %s", node.XMLString())
 		expiryTime := ag.UnixTime("expire")
-		go cli.dispatchEvent(&events.TemporaryBan{
+		go cli.dispatchEventWithMeta(&events.TemporaryBan{
 			Code:   events.TempBanReason(ag.Int("code")),
 			Expire: expiryTime,
-		})
+		}, meta)
 	} else if reason == events.ConnectFailureClientOutdated {
 		cli.Log.Errorf("Client outdated (405) connect failure")
-		go cli.dispatchEvent(&events.ClientOutdated{})
+		go cli.dispatchEventWithMeta(&events.ClientOutdated{}, meta)
 	} else {
 		cli.Log.Warnf("Unknown connect failure: %s", node.XMLString())
-		go cli.dispatchEvent(&events.ConnectFailure{Reason: reason, Raw: node})
+		go cli.dispatchEventWithMeta(&events.ConnectFailure{Reason: reason, Raw: node}, meta)
 	}
 }
 
-func (cli *Client) handleConnectSuccess(node *waBinary.Node) {
+func (cli *Client) handleConnectSuccess(node *waBinary.Node, meta *events.EventMeta) {
 	cli.Log.Infof("Successfully authenticated")
 	cli.LastSuccessfulConnect = time.Now()
+	cli.connectTimingLock.Lock()
+	cli.connectTiming.loggedIn = cli.LastSuccessfulConnect
+	cli.connectTimingLock.Unlock()
 	cli.AutoReconnectErrors = 0
 	atomic.StoreUint32(&cli.isLoggedIn, 1)
 	go func() {
@@ -125,7 +189,7 @@ func (cli *Client) handleConnectSuccess(node *waBinary.Node) {
 		if err != nil {
 			cli.Log.Warnf("Failed to send post-connect passive IQ: %v", err)
 		}
-		cli.dispatchEvent(&events.Connected{})
+		cli.dispatchEventWithMeta(&events.Connected{}, meta)
 		cli.closeSocketWaitChan()
 	}()
 }
@@ -7,19 +7,26 @@
 package whatsmeow
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
 
 	waProto "github.com/pfthink/whatsmeow/binary/proto"
 	"github.com/pfthink/whatsmeow/socket"
+	"github.com/pfthink/whatsmeow/types/events"
 	"github.com/pfthink/whatsmeow/util/cbcutil"
 	"github.com/pfthink/whatsmeow/util/hkdfutil"
 )
@@ -115,25 +122,131 @@ var mediaTypeToMMSType = map[MediaType]string{
 
 	MediaLinkThumbnail: "thumbnail-link",
 }
+var mediaTypeToMMSTypeLock sync.RWMutex
+
+// GetMMSType returns the MMS type (the media type name used in upload and download URLs) for the given media type.
+func GetMMSType(mediaType MediaType) (mmsType string, ok bool) {
+	mediaTypeToMMSTypeLock.RLock()
+	mmsType, ok = mediaTypeToMMSType[mediaType]
+	mediaTypeToMMSTypeLock.RUnlock()
+	return
+}
+
+// GetMMSTypes returns a copy of the whole media type to MMS type mapping.
+func GetMMSTypes() map[MediaType]string {
+	mediaTypeToMMSTypeLock.RLock()
+	defer mediaTypeToMMSTypeLock.RUnlock()
+	mapping := make(map[MediaType]string, len(mediaTypeToMMSType))
+	for mediaType, mmsType := range mediaTypeToMMSType {
+		mapping[mediaType] = mmsType
+	}
+	return mapping
+}
+
+// RegisterMMSType adds or replaces the MMS type used for uploading and downloading the given media type.
+//
+// This can be used to support new media types (or changed MMS types) without waiting for a library update:
+//   whatsmeow.RegisterMMSType(whatsmeow.MediaType("WhatsApp Something Keys"), "something")
+func RegisterMMSType(mediaType MediaType, mmsType string) {
+	mediaTypeToMMSTypeLock.Lock()
+	mediaTypeToMMSType[mediaType] = mmsType
+	mediaTypeToMMSTypeLock.Unlock()
+}
 
 // DownloadAny loops through the downloadable parts of the given message and downloads the first non-nil item.
-func (cli *Client) DownloadAny(msg *waProto.Message) (data []byte, err error) {
-	if msg == nil {
+//
+// Wrapper messages (view-once, ephemeral and device sent messages) are unwrapped automatically, so this can be
+// used with both the Message and RawMessage fields of events.Message.
+func (cli *Client) DownloadAny(ctx context.Context, msg *waProto.Message) (data []byte, err error) {
+	media := GetDownloadableMessage(msg)
+	if media == nil {
+		return nil, ErrNothingDownloadableFound
+	}
+	return cli.Download(ctx, media)
+}
+
+// MediaURLLifetime is the approximate time after which the URLs and direct paths of media messages stop working.
+// After that, the media has to be re-uploaded by the sender's phone (see Client.SendMediaRetryReceipt).
+const MediaURLLifetime = 14 * 24 * time.Hour
+
+// MediaExpired returns true if the given message contains media (see GetDownloadableMessage) and the download URL
+// is likely expired.
+//
+// If the URL contains an expiry timestamp (the oe query parameter, a hex unix timestamp), that is used. Otherwise the
+// message timestamp and MediaURLLifetime are used to estimate whether the URL is still valid.
+func MediaExpired(evt *events.Message) bool {
+	media, ok := GetDownloadableMessage(evt.Message).(downloadableMessageWithURL)
+	if !ok {
+		return false
+	}
+	if parsedURL, err := url.Parse(media.GetUrl()); err == nil && len(parsedURL.Query().Get("oe")) > 0 {
+		expiry, err := strconv.ParseInt(parsedURL.Query().Get("oe"), 16, 64)
+		if err == nil {
+			return time.Now().Unix() > expiry
+		}
+	}
+	return !evt.Info.Timestamp.IsZero() && time.Since(evt.Info.Timestamp) > MediaURLLifetime
+}
+
+// DownloadMessageMedia downloads the first downloadable attachment in the given message event, like DownloadAny.
+//
+// If the media URL is likely expired (see MediaExpired), this doesn't try to download it, and
+// instead requests the phone to re-upload the media with SendMediaRetryReceipt. The same is done if the
+// download fails with a 404 or 410 error. In both cases, ErrMediaRetryRequested is returned, and the response
+// will come as an *events.MediaRetry, which should be handled as described in the SendMediaRetryReceipt docs.
+func (cli *Client) DownloadMessageMedia(ctx context.Context, evt *events.Message) ([]byte, error) {
+	media := GetDownloadableMessage(evt.Message)
+	if media == nil {
 		return nil, ErrNothingDownloadableFound
 	}
+	if MediaExpired(evt) {
+		cli.Log.Debugf("Media in %s is likely expired, requesting media retry instead of downloading", evt.Info.ID)
+		return nil, cli.requestMediaRetry(evt, media, "media url is likely expired")
+	}
+	data, err := cli.Download(ctx, media)
+	if errors.Is(err, ErrMediaDownloadFailedWith404) || errors.Is(err, ErrMediaDownloadFailedWith410) {
+		return nil, cli.requestMediaRetry(evt, media, err.Error())
+	}
+	return data, err
+}
+
+func (cli *Client) requestMediaRetry(evt *events.Message, media DownloadableMessage, reason string) error {
+	err := cli.SendMediaRetryReceipt(&evt.Info, media.GetMediaKey())
+	if err != nil {
+		return fmt.Errorf("%s and failed to send media retry receipt: %w", reason, err)
+	}
+	return fmt.Errorf("%w (%s)", ErrMediaRetryRequested, reason)
+}
+
+// GetDownloadableMessage returns the first downloadable part of the given message, or nil if there isn't one.
+// This is the same part that DownloadAny downloads and MediaExpired checks.
+func GetDownloadableMessage(msg *waProto.Message) DownloadableMessage {
+	// The media keys in wrapped messages (e.g. view-once media) are the same as in normal messages,
+	// so they just need to be unwrapped. Nested wrappers are possible, e.g. a view-once message in an ephemeral chat.
+	for {
+		if inner := msg.GetDeviceSentMessage().GetMessage(); inner != nil {
+			msg = inner
+		} else if inner = msg.GetEphemeralMessage().GetMessage(); inner != nil {
+			msg = inner
+		} else if inner = msg.GetViewOnceMessage().GetMessage(); inner != nil {
+			msg = inner
+		} else {
+			break
+		}
+	}
 	switch {
-	case msg.ImageMessage != nil:
-		return cli.Download(msg.ImageMessage)
-	case msg.VideoMessage != nil:
-		return cli.Download(msg.VideoMessage)
-	case msg.AudioMessage != nil:
-		return cli.Download(msg.AudioMessage)
-	case msg.DocumentMessage != nil:
-		return cli.Download(msg.DocumentMessage)
-	case msg.StickerMessage != nil:
-		return cli.Download(msg.StickerMessage)
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage()
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage()
+	case msg.GetStickerMessage() != nil:
+		return msg.GetStickerMessage()
 	default:
-		return nil, ErrNothingDownloadableFound
+		return nil
 	}
 }
 
@@ -153,13 +266,13 @@ func getSize(msg DownloadableMessage) int {
 // This is primarily intended for downloading link preview thumbnails, which are in ExtendedTextMessage:
 //     var msg *waProto.Message
 //     ...
-//     thumbnailImageBytes, err := cli.DownloadThumbnail(msg.GetExtendedTextMessage())
-func (cli *Client) DownloadThumbnail(msg DownloadableThumbnail) ([]byte, error) {
+//     thumbnailImageBytes, err := cli.DownloadThumbnail(ctx, msg.GetExtendedTextMessage())
+func (cli *Client) DownloadThumbnail(ctx context.Context, msg DownloadableThumbnail) ([]byte, error) {
 	mediaType, ok := classToThumbnailMediaType[msg.ProtoReflect().Descriptor().Name()]
 	if !ok {
 		return nil, fmt.Errorf("%w '%s'", ErrUnknownMediaType, string(msg.ProtoReflect().Descriptor().Name()))
 	} else if len(msg.GetThumbnailDirectPath()) > 0 {
-		return cli.DownloadMediaWithPath(msg.GetThumbnailDirectPath(), msg.GetThumbnailEncSha256(), msg.GetThumbnailSha256(), msg.GetMediaKey(), -1, mediaType, mediaTypeToMMSType[mediaType])
+		return cli.DownloadMediaWithPath(ctx, msg.GetThumbnailDirectPath(), msg.GetThumbnailEncSha256(), msg.GetThumbnailSha256(), msg.GetMediaKey(), -1, mediaType, "")
 	} else {
 		return nil, ErrNoURLPresent
 	}
@@ -175,10 +288,13 @@ func GetMediaType(msg DownloadableMessage) MediaType {
 // The attachment is a specific part of a Message protobuf struct, not the message itself, e.g.
 //     var msg *waProto.Message
 //     ...
-//     imageData, err := cli.Download(msg.GetImageMessage())
+//     imageData, err := cli.Download(ctx, msg.GetImageMessage())
 //
 // You can also use DownloadAny to download the first non-nil sub-message.
-func (cli *Client) Download(msg DownloadableMessage) ([]byte, error) {
+//
+// Canceling the context aborts the HTTP request, in which case the returned error will match context.Canceled
+// (or context.DeadlineExceeded) when checked with errors.Is.
+func (cli *Client) Download(ctx context.Context, msg DownloadableMessage) ([]byte, error) {
 	mediaType, ok := classToMediaType[msg.ProtoReflect().Descriptor().Name()]
 	if !ok {
 		return nil, fmt.Errorf("%w '%s'", ErrUnknownMediaType, string(msg.ProtoReflect().Descriptor().Name()))
@@ -191,9 +307,9 @@ func (cli *Client) Download(msg DownloadableMessage) ([]byte, error) {
 		isWebWhatsappNetURL = strings.HasPrefix(urlable.GetUrl(), "https://web.whatsapp.net")
 	}
 	if len(url) > 0 && !isWebWhatsappNetURL {
-		return cli.downloadAndDecrypt(urlable.GetUrl(), msg.GetMediaKey(), mediaType, getSize(msg), msg.GetFileEncSha256(), msg.GetFileSha256())
+		return cli.downloadAndDecrypt(ctx, urlable.GetUrl(), msg.GetMediaKey(), mediaType, getSize(msg), msg.GetFileEncSha256(), msg.GetFileSha256())
 	} else if len(msg.GetDirectPath()) > 0 {
-		return cli.DownloadMediaWithPath(msg.GetDirectPath(), msg.GetFileEncSha256(), msg.GetFileSha256(), msg.GetMediaKey(), getSize(msg), mediaType, mediaTypeToMMSType[mediaType])
+		return cli.DownloadMediaWithPath(ctx, msg.GetDirectPath(), msg.GetFileEncSha256(), msg.GetFileSha256(), msg.GetMediaKey(), getSize(msg), mediaType, "")
 	} else {
 		if isWebWhatsappNetURL {
 			cli.Log.Warnf("Got a media message with a web.whatsapp.net URL (%s) and no direct path", url)
@@ -203,33 +319,35 @@ func (cli *Client) Download(msg DownloadableMessage) ([]byte, error) {
 }
 
 // DownloadMediaWithPath downloads an attachment by manually specifying the path and encryption details.
-func (cli *Client) DownloadMediaWithPath(directPath string, encFileHash, fileHash, mediaKey []byte, fileLength int, mediaType MediaType, mmsType string) (data []byte, err error) {
+func (cli *Client) DownloadMediaWithPath(ctx context.Context, directPath string, encFileHash, fileHash, mediaKey []byte, fileLength int, mediaType MediaType, mmsType string) (data []byte, err error) {
 	var mediaConn *MediaConn
 	mediaConn, err = cli.refreshMediaConn(false)
 	if err != nil {
 		return nil, fmt.Errorf("failed to refresh media connections: %w", err)
 	}
 	if len(mmsType) == 0 {
-		mmsType = mediaTypeToMMSType[mediaType]
+		mmsType, _ = GetMMSType(mediaType)
 	}
 	for i, host := range mediaConn.Hosts {
 		mediaURL := fmt.Sprintf("https://%s%s&hash=%s&mms-type=%s&__wa-mms=", host.Hostname, directPath, base64.URLEncoding.EncodeToString(encFileHash), mmsType)
-		data, err = cli.downloadAndDecrypt(mediaURL, mediaKey, mediaType, fileLength, encFileHash, fileHash)
+		data, err = cli.downloadAndDecrypt(ctx, mediaURL, mediaKey, mediaType, fileLength, encFileHash, fileHash)
 		// TODO there are probably some errors that shouldn't retry
-		if err != nil {
-			if i >= len(mediaConn.Hosts)-1 {
-				return nil, fmt.Errorf("failed to download media from last host: %w", err)
-			}
-			cli.Log.Warnf("Failed to download media: %s, trying with next host...", err)
+		if err == nil {
+			return
+		} else if ctx.Err() != nil {
+			return nil, err
+		} else if i >= len(mediaConn.Hosts)-1 {
+			return nil, fmt.Errorf("failed to download media from last host: %w", err)
 		}
+		cli.Log.Warnf("Failed to download media: %s, trying with next host...", err)
 	}
 	return
 }
 
-func (cli *Client) downloadAndDecrypt(url string, mediaKey []byte, appInfo MediaType, fileLength int, fileEncSha256, fileSha256 []byte) (data []byte, err error) {
+func (cli *Client) downloadAndDecrypt(ctx context.Context, url string, mediaKey []byte, appInfo MediaType, fileLength int, fileEncSha256, fileSha256 []byte) (data []byte, err error) {
 	iv, cipherKey, macKey, _ := getMediaKeys(mediaKey, appInfo)
 	var ciphertext, mac []byte
-	if ciphertext, mac, err = cli.downloadEncryptedMedia(url, fileEncSha256); err != nil {
+	if ciphertext, mac, err = cli.downloadEncryptedMedia(ctx, url, fileEncSha256); err != nil {
 
 	} else if err = validateMedia(iv, ciphertext, macKey, mac); err != nil {
 
@@ -248,9 +366,9 @@ func getMediaKeys(mediaKey []byte, appInfo MediaType) (iv, cipherKey, macKey, re
 	return mediaKeyExpanded[:16], mediaKeyExpanded[16:48], mediaKeyExpanded[48:80], mediaKeyExpanded[80:]
 }
 
-func (cli *Client) downloadEncryptedMedia(url string, checksum []byte) (file, mac []byte, err error) {
+func (cli *Client) downloadEncryptedMedia(ctx context.Context, url string, checksum []byte) (file, mac []byte, err error) {
 	var req *http.Request
-	req, err = http.NewRequest(http.MethodGet, url, nil)
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		err = fmt.Errorf("failed to prepare request: %w", err)
 		return
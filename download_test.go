@@ -0,0 +1,236 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	waBinary "github.com/pfthink/whatsmeow/binary"
+	waProto "github.com/pfthink/whatsmeow/binary/proto"
+	"github.com/pfthink/whatsmeow/store"
+	"github.com/pfthink/whatsmeow/types"
+	"github.com/pfthink/whatsmeow/types/events"
+	"github.com/pfthink/whatsmeow/util/cbcutil"
+	waLog "github.com/pfthink/whatsmeow/util/log"
+)
+
+// encryptTestMedia encrypts the given plaintext the same way Upload does and returns the file to serve.
+func encryptTestMedia(t *testing.T, plaintext []byte, mediaType MediaType) (file []byte, resp UploadResponse) {
+	resp.MediaKey = bytes.Repeat([]byte{0x42}, 32)
+	resp.FileLength = uint64(len(plaintext))
+	plaintextSHA256 := sha256.Sum256(plaintext)
+	resp.FileSHA256 = plaintextSHA256[:]
+	iv, cipherKey, macKey, _ := getMediaKeys(resp.MediaKey, mediaType)
+	ciphertext, err := cbcutil.Encrypt(cipherKey, iv, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := hmac.New(sha256.New, macKey)
+	h.Write(iv)
+	h.Write(ciphertext)
+	file = append(ciphertext, h.Sum(nil)[:10]...)
+	fileEncSHA256 := sha256.Sum256(file)
+	resp.FileEncSHA256 = fileEncSHA256[:]
+	return
+}
+
+func TestDownloadAnyViewOnce(t *testing.T) {
+	plaintext := []byte("this is some view-once media that should only be seen once")
+	files := make(map[string][]byte)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(file)
+	}))
+	defer server.Close()
+	cli := &Client{http: server.Client(), Log: waLog.Noop}
+
+	makeMessage := func(mediaType MediaType, path string) *waProto.Message {
+		file, resp := encryptTestMedia(t, plaintext, mediaType)
+		files[path] = file
+		switch mediaType {
+		case MediaImage:
+			return &waProto.Message{ImageMessage: &waProto.ImageMessage{
+				Url:           proto.String(server.URL + path),
+				Mimetype:      proto.String("image/jpeg"),
+				MediaKey:      resp.MediaKey,
+				FileEncSha256: resp.FileEncSHA256,
+				FileSha256:    resp.FileSHA256,
+				FileLength:    proto.Uint64(resp.FileLength),
+				ViewOnce:      proto.Bool(true),
+			}}
+		case MediaVideo:
+			return &waProto.Message{VideoMessage: &waProto.VideoMessage{
+				Url:           proto.String(server.URL + path),
+				Mimetype:      proto.String("video/mp4"),
+				MediaKey:      resp.MediaKey,
+				FileEncSha256: resp.FileEncSHA256,
+				FileSha256:    resp.FileSHA256,
+				FileLength:    proto.Uint64(resp.FileLength),
+				ViewOnce:      proto.Bool(true),
+			}}
+		default:
+			return &waProto.Message{AudioMessage: &waProto.AudioMessage{
+				Url:           proto.String(server.URL + path),
+				Mimetype:      proto.String("audio/ogg; codecs=opus"),
+				MediaKey:      resp.MediaKey,
+				FileEncSha256: resp.FileEncSHA256,
+				FileSha256:    resp.FileSHA256,
+				FileLength:    proto.Uint64(resp.FileLength),
+			}}
+		}
+	}
+
+	tests := []struct {
+		name      string
+		mediaType MediaType
+	}{
+		{"image", MediaImage},
+		{"video", MediaVideo},
+		{"audio", MediaAudio},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inner := makeMessage(test.mediaType, "/"+test.name)
+			wrapped := map[string]*waProto.Message{
+				"view once": {ViewOnceMessage: &waProto.FutureProofMessage{Message: inner}},
+				"ephemeral view once": {EphemeralMessage: &waProto.FutureProofMessage{Message: &waProto.Message{
+					ViewOnceMessage: &waProto.FutureProofMessage{Message: inner},
+				}}},
+				"device sent view once": {DeviceSentMessage: &waProto.DeviceSentMessage{Message: &waProto.Message{
+					ViewOnceMessage: &waProto.FutureProofMessage{Message: inner},
+				}}},
+			}
+			for name, msg := range wrapped {
+				data, err := cli.DownloadAny(context.Background(), msg)
+				if err != nil {
+					t.Errorf("%s: failed to download: %v", name, err)
+				} else if !bytes.Equal(data, plaintext) {
+					t.Errorf("%s: downloaded data doesn't match: %q", name, data)
+				}
+			}
+		})
+	}
+}
+
+func TestMediaExpired(t *testing.T) {
+	now := time.Now()
+	imageWithURL := func(url string) *waProto.Message {
+		return &waProto.Message{ImageMessage: &waProto.ImageMessage{Url: proto.String(url)}}
+	}
+	withExpiry := func(expiry time.Time) string {
+		return fmt.Sprintf("https://mmg.whatsapp.net/v/t62.7118-24/1234.enc?ccb=11-4&oh=abcd&oe=%X", expiry.Unix())
+	}
+	tests := []struct {
+		name      string
+		msg       *waProto.Message
+		timestamp time.Time
+		expected  bool
+	}{
+		{"Expiry in the future", imageWithURL(withExpiry(now.Add(time.Hour))), now.Add(-30 * 24 * time.Hour), false},
+		{"Expiry in the past", imageWithURL(withExpiry(now.Add(-time.Hour))), now, true},
+		{"Lowercase hex expiry", imageWithURL(fmt.Sprintf("https://mmg.whatsapp.net/file.enc?oe=%x", now.Add(time.Hour).Unix())), time.Time{}, false},
+		{"Invalid expiry falls back to old timestamp", imageWithURL("https://mmg.whatsapp.net/file.enc?oe=nothex"), now.Add(-MediaURLLifetime - time.Hour), true},
+		{"Invalid expiry falls back to recent timestamp", imageWithURL("https://mmg.whatsapp.net/file.enc?oe=nothex"), now, false},
+		{"No expiry and old timestamp", imageWithURL("https://mmg.whatsapp.net/file.enc"), now.Add(-MediaURLLifetime - time.Hour), true},
+		{"No expiry and recent timestamp", imageWithURL("https://mmg.whatsapp.net/file.enc"), now.Add(-time.Hour), false},
+		{"No expiry and no timestamp", imageWithURL("https://mmg.whatsapp.net/file.enc"), time.Time{}, false},
+		{"Wrapped media", &waProto.Message{ViewOnceMessage: &waProto.FutureProofMessage{Message: imageWithURL(withExpiry(now.Add(-time.Hour)))}}, now, true},
+		{"No media", &waProto.Message{Conversation: proto.String("hi")}, now.Add(-MediaURLLifetime - time.Hour), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			evt := &events.Message{Info: types.MessageInfo{Timestamp: test.timestamp}, Message: test.msg}
+			if expired := MediaExpired(evt); expired != test.expected {
+				t.Errorf("Expected MediaExpired to return %t, got %t", test.expected, expired)
+			}
+		})
+	}
+}
+
+func TestDownloadMessageMediaRequestsRetry(t *testing.T) {
+	var requests int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	ownID := types.NewADJID("9999", 0, 1)
+	cli := NewClient(&store.Device{ID: &ownID}, nil)
+	cli.http = server.Client()
+	retryReceipts := make(chan types.MessageID, 1)
+	cli.OnFrameSent = func(_ []byte, node *waBinary.Node) {
+		if node != nil && node.Tag == "receipt" && node.Attrs["type"] == "server-error" {
+			retryReceipts <- types.MessageID(node.AttrGetter().String("id"))
+		}
+	}
+	connectTestSocket(t, cli)
+	defer cli.Disconnect()
+
+	tests := []struct {
+		name         string
+		path         string
+		timestamp    time.Time
+		httpRequests int32
+	}{
+		{"Not found", "/missing", time.Now(), 1},
+		{"Gone", "/gone", time.Now(), 1},
+		{"Likely expired", "/never-requested", time.Now().Add(-MediaURLLifetime - time.Hour), 0},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			atomic.StoreInt32(&requests, 0)
+			id := types.MessageID(fmt.Sprintf("MSG%d", i))
+			evt := &events.Message{
+				Info: types.MessageInfo{
+					MessageSource: types.MessageSource{Chat: types.NewJID("1111", types.DefaultUserServer), Sender: types.NewJID("1111", types.DefaultUserServer)},
+					ID:            id,
+					Timestamp:     test.timestamp,
+				},
+				Message: &waProto.Message{ImageMessage: &waProto.ImageMessage{
+					Url:      proto.String(server.URL + test.path),
+					MediaKey: bytes.Repeat([]byte{0x42}, 32),
+				}},
+			}
+			_, err := cli.DownloadMessageMedia(context.Background(), evt)
+			if !errors.Is(err, ErrMediaRetryRequested) {
+				t.Fatalf("Expected ErrMediaRetryRequested, got %v", err)
+			}
+			select {
+			case receiptID := <-retryReceipts:
+				if receiptID != id {
+					t.Errorf("Expected media retry receipt for %s, got %s", id, receiptID)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Media retry receipt wasn't sent")
+			}
+			if count := atomic.LoadInt32(&requests); count != test.httpRequests {
+				t.Errorf("Expected %d download requests, got %d", test.httpRequests, count)
+			}
+		})
+	}
+}
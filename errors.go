@@ -21,6 +21,8 @@ var (
 	ErrNotLoggedIn  = errors.New("the store doesn't contain a device JID")
 
 	ErrAlreadyConnected = errors.New("websocket is already connected")
+	ErrHandshakeTimeout = errors.New("timed out waiting for handshake response")
+	ErrConnectCanceled  = errors.New("connecting was canceled by Disconnect")
 
 	ErrQRAlreadyConnected = errors.New("GetQRChannel must be called before connecting")
 	ErrQRStoreContainsID  = errors.New("GetQRChannel can only be called when there's no user ID in the client's Store")
@@ -32,6 +34,8 @@ var (
 	// ErrProfilePictureUnauthorized is returned by GetProfilePictureInfo when trying to get the profile picture of a user
 	// whose privacy settings prevent you from seeing their profile picture (status code 401).
 	ErrProfilePictureUnauthorized = errors.New("the user has hidden their profile picture from you")
+	// ErrProfilePictureNotModified is returned by GetProfilePicture if the picture ID matches the given existing ID.
+	ErrProfilePictureNotModified = errors.New("the profile picture hasn't changed")
 	// ErrGroupInviteLinkUnauthorized is returned by GetGroupInviteLink if you don't have the permission to get the link (status code 401).
 	ErrGroupInviteLinkUnauthorized = errors.New("you don't have the permission to get the group's invite link")
 	// ErrNotInGroup is returned by group info getting methods if you're not in the group (status code 403).
@@ -52,6 +56,22 @@ var (
 	ErrUnknownMediaRetryError = errors.New("unknown media retry error")
 	// ErrInvalidDisappearingTimer is returned by SetDisappearingTimer if the given timer is not one of the allowed values.
 	ErrInvalidDisappearingTimer = errors.New("invalid disappearing timer provided")
+	// ErrNotCommunity is returned by SendCommunityAnnouncement if the given group is not a community.
+	ErrNotCommunity = errors.New("that group is not a community")
+	// ErrNotCommunityAdmin is returned by SendCommunityAnnouncement if you're not an admin of the community.
+	ErrNotCommunityAdmin = errors.New("you're not an admin of that community")
+	// ErrCommunityAnnouncementGroupNotFound is returned by GetCommunityAnnouncementGroup if the community doesn't have an announcement group.
+	ErrCommunityAnnouncementGroupNotFound = errors.New("that community doesn't have an announcement group")
+	// ErrUnknownPollOption is returned by PollAggregator.AddVote if the vote contains an option hash that isn't in the poll.
+	ErrUnknownPollOption = errors.New("vote contains unknown poll option")
+	// ErrDuplicatePollOption is returned by NewPollAggregator if the poll has several options with the same name.
+	ErrDuplicatePollOption = errors.New("poll contains duplicate option names")
+	// ErrMessageInfoNotFound is returned by GetMessageInfo if the message isn't in the recently sent message cache.
+	ErrMessageInfoNotFound = errors.New("no receipt info found for that message")
+	// ErrInvalidStatusPrivacyType is returned by SetStatusPrivacy if the given type is not one of the known types.
+	ErrInvalidStatusPrivacyType = errors.New("invalid status privacy type provided")
+	// ErrClockSkew is returned in PairError events if pairing failed and the local clock seems to be wrong.
+	ErrClockSkew = errors.New("pairing failed, the system clock is likely wrong")
 )
 
 // Some errors that Client.SendMessage can return
@@ -73,6 +93,7 @@ var (
 	ErrInvalidMediaSHA256         = errors.New("hash of media plaintext doesn't match")
 	ErrUnknownMediaType           = errors.New("unknown media type")
 	ErrNothingDownloadableFound   = errors.New("didn't find any attachments in message")
+	ErrMediaRetryRequested        = errors.New("media is expired, requested re-upload from phone")
 )
 
 type wrappedIQError struct {
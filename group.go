@@ -38,7 +38,7 @@ func (cli *Client) CreateGroup(name string, participants []types.JID) (*types.Gr
 			Attrs: waBinary.Attrs{"jid": participant},
 		}
 	}
-	key := GenerateMessageID()
+	key := cli.GenerateMessageID()
 	resp, err := cli.sendGroupIQ(iqSet, types.GroupServerJID, waBinary.Node{
 		Tag: "create",
 		Attrs: waBinary.Attrs{
@@ -162,7 +162,7 @@ func (cli *Client) SetGroupTopic(jid types.JID, previousID, newID, topic string)
 		previousID = oldInfo.TopicID
 	}
 	if newID == "" {
-		newID = GenerateMessageID()
+		newID = cli.GenerateMessageID()
 	}
 	_, err := cli.sendGroupIQ(iqSet, jid, waBinary.Node{
 		Tag: "description",
@@ -198,6 +198,32 @@ func (cli *Client) SetGroupAnnounce(jid types.JID, announce bool) error {
 	return err
 }
 
+// SetGroupMemberAddMode changes who can add new members to the group.
+func (cli *Client) SetGroupMemberAddMode(jid types.JID, mode types.GroupMemberAddMode) error {
+	if mode != types.GroupMemberAddModeAdmin && mode != types.GroupMemberAddModeAllMember {
+		return fmt.Errorf("invalid group member add mode %q", mode)
+	}
+	_, err := cli.sendGroupIQ(iqSet, jid, waBinary.Node{Tag: "member_add_mode", Content: []byte(mode)})
+	return err
+}
+
+// SetGroupJoinApprovalMode changes whether new members need to be approved by an admin before they can join
+// the group. Pending requests can be handled with GetGroupJoinRequests and UpdateGroupJoinRequests.
+func (cli *Client) SetGroupJoinApprovalMode(jid types.JID, approvalRequired bool) error {
+	state := "off"
+	if approvalRequired {
+		state = "on"
+	}
+	_, err := cli.sendGroupIQ(iqSet, jid, waBinary.Node{
+		Tag: "membership_approval_mode",
+		Content: []waBinary.Node{{
+			Tag:   "group_join",
+			Attrs: waBinary.Attrs{"state": state},
+		}},
+	})
+	return err
+}
+
 // GetGroupInviteLink requests the invite link to the group from the WhatsApp servers.
 //
 // If reset is true, then the old invite link will be revoked and a new one generated.
@@ -306,6 +332,80 @@ func (cli *Client) JoinGroupWithLink(code string) (types.JID, error) {
 	return groupNode.AttrGetter().JID("jid"), nil
 }
 
+// GetGroupJoinRequests gets the list of pending requests to join the given group.
+// Only admins of groups that require admin approval to join can get the list.
+func (cli *Client) GetGroupJoinRequests(jid types.JID) ([]types.GroupJoinRequest, error) {
+	resp, err := cli.sendGroupIQ(iqGet, jid, waBinary.Node{Tag: "membership_approval_requests"})
+	if err != nil {
+		return nil, err
+	}
+	return parseGroupJoinRequests(resp)
+}
+
+func parseGroupJoinRequests(resp *waBinary.Node) ([]types.GroupJoinRequest, error) {
+	requests, ok := resp.GetOptionalChildByTag("membership_approval_requests")
+	if !ok {
+		return nil, &ElementMissingError{Tag: "membership_approval_requests", In: "response to group join request list query"}
+	}
+	children := requests.GetChildren()
+	output := make([]types.GroupJoinRequest, 0, len(children))
+	for _, child := range children {
+		if child.Tag != "membership_approval_request" {
+			continue
+		}
+		ag := child.AttrGetter()
+		request := types.GroupJoinRequest{
+			JID:         ag.JID("jid"),
+			RequestedAt: ag.UnixTime("request_time"),
+		}
+		if !ag.OK() {
+			return nil, fmt.Errorf("group join request doesn't contain required attributes: %w", ag.Error())
+		}
+		output = append(output, request)
+	}
+	return output, nil
+}
+
+// UpdateGroupJoinRequests approves or rejects the pending requests of the given users to join the given group.
+//
+// If some of the requests couldn't be updated (e.g. because the user already cancelled the request),
+// an error listing them is returned, but the other requests are still updated.
+func (cli *Client) UpdateGroupJoinRequests(jid types.JID, participants []types.JID, approve bool) error {
+	action := "reject"
+	if approve {
+		action = "approve"
+	}
+	participantNodes := make([]waBinary.Node, len(participants))
+	for i, participant := range participants {
+		participantNodes[i] = waBinary.Node{
+			Tag:   "participant",
+			Attrs: waBinary.Attrs{"jid": participant},
+		}
+	}
+	resp, err := cli.sendGroupIQ(iqSet, jid, waBinary.Node{
+		Tag: "membership_requests_action",
+		Content: []waBinary.Node{{
+			Tag:     action,
+			Content: participantNodes,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	actionResp := resp.GetChildByTag("membership_requests_action", action)
+	var failed []string
+	for _, child := range actionResp.GetChildren() {
+		ag := child.AttrGetter()
+		if child.Tag == "participant" && ag.OptionalString("error") != "" {
+			failed = append(failed, fmt.Sprintf("%s (%s)", ag.OptionalJIDOrEmpty("jid"), ag.OptionalString("error")))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to %s some join requests: %s", action, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
 // GetJoinedGroups returns the list of groups the user is participating in.
 func (cli *Client) GetJoinedGroups() ([]*types.GroupInfo, error) {
 	resp, err := cli.sendGroupIQ(iqGet, types.GroupServerJID, waBinary.Node{
@@ -376,6 +476,56 @@ func (cli *Client) getGroupInfo(jid types.JID, lockParticipantCache bool) (*type
 	return groupInfo, nil
 }
 
+// GetSubGroups gets the groups linked to the given community.
+func (cli *Client) GetSubGroups(community types.JID) ([]*types.GroupLinkTarget, error) {
+	res, err := cli.sendGroupIQ(iqGet, community, waBinary.Node{Tag: "sub_groups"})
+	if errors.Is(err, ErrIQNotFound) {
+		return nil, wrapIQError(ErrGroupNotFound, err)
+	} else if errors.Is(err, ErrIQForbidden) {
+		return nil, wrapIQError(ErrNotInGroup, err)
+	} else if err != nil {
+		return nil, err
+	}
+	groups, ok := res.GetOptionalChildByTag("sub_groups")
+	if !ok {
+		return nil, &ElementMissingError{Tag: "sub_groups", In: "response to subgroups query"}
+	}
+	var parsedGroups []*types.GroupLinkTarget
+	for _, child := range groups.GetChildren() {
+		if child.Tag != "group" {
+			continue
+		}
+		ag := child.AttrGetter()
+		target := &types.GroupLinkTarget{
+			JID: types.NewJID(ag.String("id"), types.GroupServer),
+			GroupName: types.GroupName{
+				Name:      ag.OptionalString("subject"),
+				NameSetAt: ag.OptionalUnixTime("s_t"),
+			},
+		}
+		_, target.IsDefaultSubGroup = child.GetOptionalChildByTag("default_sub_group")
+		if !ag.OK() {
+			return nil, fmt.Errorf("failed to parse subgroup: %w", ag.Error())
+		}
+		parsedGroups = append(parsedGroups, target)
+	}
+	return parsedGroups, nil
+}
+
+// GetCommunityAnnouncementGroup finds the default announcement group of the given community.
+func (cli *Client) GetCommunityAnnouncementGroup(community types.JID) (types.JID, error) {
+	subGroups, err := cli.GetSubGroups(community)
+	if err != nil {
+		return types.EmptyJID, err
+	}
+	for _, group := range subGroups {
+		if group.IsDefaultSubGroup {
+			return group.JID, nil
+		}
+	}
+	return types.EmptyJID, ErrCommunityAnnouncementGroupNotFound
+}
+
 func (cli *Client) getGroupMembers(jid types.JID) ([]types.JID, error) {
 	cli.groupParticipantsCacheLock.Lock()
 	defer cli.groupParticipantsCacheLock.Unlock()
@@ -431,6 +581,18 @@ func (cli *Client) parseGroupNode(groupNode *waBinary.Node) (*types.GroupInfo, e
 		case "ephemeral":
 			group.IsEphemeral = true
 			group.DisappearingTimer = uint32(childAG.Uint64("expiration"))
+		case "parent":
+			group.IsParent = true
+		case "linked_parent":
+			group.LinkedParentJID = childAG.JID("jid")
+		case "default_sub_group":
+			group.IsDefaultSubGroup = true
+		case "member_add_mode":
+			modeBytes, _ := child.Content.([]byte)
+			group.MemberAddMode = types.GroupMemberAddMode(modeBytes)
+		case "membership_approval_mode":
+			groupJoin := child.GetChildByTag("group_join")
+			group.IsJoinApprovalRequired = groupJoin.AttrGetter().OptionalString("state") == "on"
 		default:
 			cli.Log.Debugf("Unknown element in group node %s: %s", group.JID.String(), child.XMLString())
 		}
@@ -470,15 +632,17 @@ func (cli *Client) parseGroupCreate(node *waBinary.Node) (*events.JoinedGroup, e
 	return &evt, nil
 }
 
-func (cli *Client) parseGroupChange(node *waBinary.Node) (*events.GroupInfo, error) {
+func (cli *Client) parseGroupChange(node *waBinary.Node) (*events.GroupInfo, []*events.GroupJoinRequest, error) {
 	var evt events.GroupInfo
+	var joinRequests []*events.GroupJoinRequest
+	joinRequestNodes := 0
 	ag := node.AttrGetter()
 	evt.JID = ag.JID("from")
 	evt.Notify = ag.OptionalString("notify")
 	evt.Sender = ag.OptionalJID("participant")
 	evt.Timestamp = ag.UnixTime("t")
 	if !ag.OK() {
-		return nil, fmt.Errorf("group change doesn't contain required attributes: %w", ag.Error())
+		return nil, nil, fmt.Errorf("group change doesn't contain required attributes: %w", ag.Error())
 	}
 
 	for _, child := range node.GetChildren() {
@@ -511,7 +675,7 @@ func (cli *Client) parseGroupChange(node *waBinary.Node) (*events.GroupInfo, err
 			topicChild := child.GetChildByTag("body")
 			topicBytes, ok := topicChild.Content.([]byte)
 			if !ok {
-				return nil, fmt.Errorf("group change description has unexpected body: %s", topicChild.XMLString())
+				return nil, nil, fmt.Errorf("group change description has unexpected body: %s", topicChild.XMLString())
 			}
 			var setBy types.JID
 			if evt.Sender != nil {
@@ -544,14 +708,38 @@ func (cli *Client) parseGroupChange(node *waBinary.Node) (*events.GroupInfo, err
 			}
 		case "not_ephemeral":
 			evt.Ephemeral = &types.GroupEphemeral{IsEphemeral: false}
+		case "member_add_mode":
+			modeBytes, _ := child.Content.([]byte)
+			mode := types.GroupMemberAddMode(modeBytes)
+			evt.MemberAddMode = &mode
+		case "membership_approval_mode":
+			groupJoin := child.GetChildByTag("group_join")
+			evt.MembershipApprovalMode = &types.GroupMembershipApprovalMode{
+				IsJoinApprovalRequired: groupJoin.AttrGetter().OptionalString("state") == "on",
+			}
+		case "created_membership_requests":
+			joinRequestNodes++
+			requestMethod := cag.OptionalString("request_method")
+			for _, requester := range parseParticipantList(&child) {
+				joinRequests = append(joinRequests, &events.GroupJoinRequest{
+					JID:           evt.JID,
+					Requester:     requester,
+					RequestMethod: requestMethod,
+					Timestamp:     evt.Timestamp,
+				})
+			}
 		default:
 			evt.UnknownChanges = append(evt.UnknownChanges, &child)
 		}
 		if !cag.OK() {
-			return nil, fmt.Errorf("group change %s element doesn't contain required attributes: %w", child.Tag, cag.Error())
+			return nil, nil, fmt.Errorf("group change %s element doesn't contain required attributes: %w", child.Tag, cag.Error())
 		}
 	}
-	return &evt, nil
+	if joinRequestNodes > 0 && joinRequestNodes == len(node.GetChildren()) {
+		// Don't emit an empty group info event if the notification only contained join requests
+		return nil, joinRequests, nil
+	}
+	return &evt, joinRequests, nil
 }
 
 func (cli *Client) updateGroupParticipantCache(evt *events.GroupInfo) {
@@ -585,16 +773,27 @@ Outer:
 	cli.groupParticipantsCache[evt.JID] = cached
 }
 
-func (cli *Client) parseGroupNotification(node *waBinary.Node) (interface{}, error) {
+func (cli *Client) parseGroupNotification(node *waBinary.Node) ([]interface{}, error) {
 	children := node.GetChildren()
 	if len(children) == 1 && children[0].Tag == "create" {
-		return cli.parseGroupCreate(&children[0])
+		evt, err := cli.parseGroupCreate(&children[0])
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{evt}, nil
 	} else {
-		groupChange, err := cli.parseGroupChange(node)
+		groupChange, joinRequests, err := cli.parseGroupChange(node)
 		if err != nil {
 			return nil, err
 		}
-		cli.updateGroupParticipantCache(groupChange)
-		return groupChange, nil
+		evts := make([]interface{}, 0, 1+len(joinRequests))
+		if groupChange != nil {
+			cli.updateGroupParticipantCache(groupChange)
+			evts = append(evts, groupChange)
+		}
+		for _, joinRequest := range joinRequests {
+			evts = append(evts, joinRequest)
+		}
+		return evts, nil
 	}
 }
@@ -0,0 +1,200 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	waBinary "github.com/pfthink/whatsmeow/binary"
+	"github.com/pfthink/whatsmeow/store"
+	"github.com/pfthink/whatsmeow/types"
+	"github.com/pfthink/whatsmeow/types/events"
+)
+
+var (
+	groupTestJID   = types.NewJID("123456789-1656000000", types.GroupServer)
+	groupTestAdmin = types.NewJID("1111", types.DefaultUserServer)
+	groupTestAlice = types.NewJID("2222", types.DefaultUserServer)
+	groupTestBob   = types.NewJID("3333", types.DefaultUserServer)
+)
+
+func groupTestParticipants(jids ...types.JID) []waBinary.Node {
+	nodes := make([]waBinary.Node, len(jids))
+	for i, jid := range jids {
+		nodes[i] = waBinary.Node{Tag: "participant", Attrs: waBinary.Attrs{"jid": jid}}
+	}
+	return nodes
+}
+
+func groupTestJoinRequests(jids ...types.JID) waBinary.Node {
+	return waBinary.Node{
+		Tag:     "created_membership_requests",
+		Attrs:   waBinary.Attrs{"request_method": "invite_link"},
+		Content: groupTestParticipants(jids...),
+	}
+}
+
+func TestParseGroupNotificationJoinRequests(t *testing.T) {
+	ts := time.Unix(1656000000, 0)
+	tests := []struct {
+		name        string
+		changes     []waBinary.Node
+		expectInfo  bool
+		requesters  []types.JID
+		expectLeave []types.JID
+	}{
+		{
+			name:       "Only join requests",
+			changes:    []waBinary.Node{groupTestJoinRequests(groupTestAlice, groupTestBob)},
+			requesters: []types.JID{groupTestAlice, groupTestBob},
+		},
+		{
+			name:       "Multiple join request elements",
+			changes:    []waBinary.Node{groupTestJoinRequests(groupTestAlice), groupTestJoinRequests(groupTestBob)},
+			requesters: []types.JID{groupTestAlice, groupTestBob},
+		},
+		{
+			name: "Join requests with other changes",
+			changes: []waBinary.Node{
+				groupTestJoinRequests(groupTestAlice, groupTestBob),
+				{Tag: "remove", Attrs: waBinary.Attrs{"prev_v_id": "1", "v_id": "2"}, Content: groupTestParticipants(groupTestAdmin)},
+			},
+			expectInfo:  true,
+			requesters:  []types.JID{groupTestAlice, groupTestBob},
+			expectLeave: []types.JID{groupTestAdmin},
+		},
+		{
+			name: "Single requester with another change",
+			changes: []waBinary.Node{
+				groupTestJoinRequests(groupTestAlice),
+				{Tag: "locked"},
+			},
+			expectInfo: true,
+			requesters: []types.JID{groupTestAlice},
+		},
+	}
+	cli := NewClient(&store.Device{}, nil)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			evts, err := cli.parseGroupNotification(&waBinary.Node{
+				Tag: "notification",
+				Attrs: waBinary.Attrs{
+					"from":        groupTestJID,
+					"participant": groupTestAdmin,
+					"t":           "1656000000",
+					"type":        "w:gp2",
+				},
+				Content: test.changes,
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var info *events.GroupInfo
+			var requesters []types.JID
+			for _, evt := range evts {
+				switch typedEvt := evt.(type) {
+				case *events.GroupInfo:
+					info = typedEvt
+				case *events.GroupJoinRequest:
+					if typedEvt.JID != groupTestJID || typedEvt.RequestMethod != "invite_link" || !typedEvt.Timestamp.Equal(ts) {
+						t.Errorf("Unexpected join request event %+v", typedEvt)
+					}
+					requesters = append(requesters, typedEvt.Requester)
+				default:
+					t.Errorf("Unexpected event %T", evt)
+				}
+			}
+			if !reflect.DeepEqual(requesters, test.requesters) {
+				t.Errorf("Expected join requests from %v, got %v", test.requesters, requesters)
+			}
+			if !test.expectInfo {
+				if info != nil {
+					t.Errorf("Expected no group info event, got %+v", info)
+				}
+				return
+			} else if info == nil {
+				t.Fatal("Group info event for the other changes is missing")
+			}
+			if !reflect.DeepEqual(info.Leave, test.expectLeave) {
+				t.Errorf("Expected %v to leave, got %v", test.expectLeave, info.Leave)
+			}
+			if len(info.UnknownChanges) != 0 {
+				t.Errorf("Expected no unknown changes, got %d", len(info.UnknownChanges))
+			}
+		})
+	}
+}
+
+func TestParseGroupJoinRequests(t *testing.T) {
+	request := func(jid types.JID, requestTime string) waBinary.Node {
+		return waBinary.Node{Tag: "membership_approval_request", Attrs: waBinary.Attrs{"jid": jid, "request_time": requestTime}}
+	}
+	tests := []struct {
+		name     string
+		resp     waBinary.Node
+		expected []types.GroupJoinRequest
+		// missingList means the response has no request list, invalid means a request in the list is invalid
+		missingList, invalid bool
+	}{
+		{
+			name: "Pending requests",
+			resp: waBinary.Node{Tag: "group", Content: []waBinary.Node{{
+				Tag: "membership_approval_requests",
+				Content: []waBinary.Node{
+					request(groupTestAlice, "1656000000"),
+					{Tag: "something_else"},
+					request(groupTestBob, "1656000060"),
+				},
+			}}},
+			expected: []types.GroupJoinRequest{
+				{JID: groupTestAlice, RequestedAt: time.Unix(1656000000, 0)},
+				{JID: groupTestBob, RequestedAt: time.Unix(1656000060, 0)},
+			},
+		},
+		{
+			name:     "No pending requests",
+			resp:     waBinary.Node{Tag: "group", Content: []waBinary.Node{{Tag: "membership_approval_requests"}}},
+			expected: []types.GroupJoinRequest{},
+		},
+		{
+			name:        "Missing request list",
+			resp:        waBinary.Node{Tag: "group"},
+			missingList: true,
+		},
+		{
+			name: "Request without time",
+			resp: waBinary.Node{Tag: "group", Content: []waBinary.Node{{
+				Tag:     "membership_approval_requests",
+				Content: []waBinary.Node{{Tag: "membership_approval_request", Attrs: waBinary.Attrs{"jid": groupTestAlice}}},
+			}}},
+			invalid: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests, err := parseGroupJoinRequests(&test.resp)
+			var missingErr *ElementMissingError
+			switch {
+			case test.missingList:
+				if !errors.As(err, &missingErr) {
+					t.Errorf("Expected ElementMissingError, got %v", err)
+				}
+			case test.invalid:
+				if err == nil {
+					t.Errorf("Expected error, got %v", requests)
+				}
+			case err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			case !reflect.DeepEqual(requests, test.expected):
+				t.Errorf("Expected %v, got %v", test.expected, requests)
+			}
+		})
+	}
+}
@@ -33,16 +33,23 @@ func (cli *Client) doHandshake(fs *socket.FrameSocket, ephemeralKP keys.KeyPair)
 	if err != nil {
 		return fmt.Errorf("failed to marshal handshake message: %w", err)
 	}
+	// The frame socket prepends the connection header to the first frame it sends
+	connHeader := fs.Header
 	err = fs.SendFrame(data)
 	if err != nil {
 		return fmt.Errorf("failed to send handshake message: %w", err)
 	}
+	if len(connHeader) > 0 {
+		cli.frameSent(connHeader, nil)
+	}
+	cli.frameSent(data, nil)
 	var resp []byte
 	select {
 	case resp = <-fs.Frames:
 	case <-time.After(NoiseHandshakeResponseTimeout):
-		return fmt.Errorf("timed out waiting for handshake response")
+		return ErrHandshakeTimeout
 	}
+	cli.frameReceived(resp, nil)
 	var handshakeResponse waProto.HandshakeMessage
 	err = proto.Unmarshal(resp, &handshakeResponse)
 	if err != nil {
@@ -119,6 +126,12 @@ func (cli *Client) doHandshake(fs *socket.FrameSocket, ephemeralKP keys.KeyPair)
 	if err != nil {
 		return fmt.Errorf("failed to send handshake finish message: %w", err)
 	}
+	cli.frameSent(data, nil)
+
+	// Frames are handled as soon as the noise socket is created, so the timing has to be stored before that
+	cli.connectTimingLock.Lock()
+	cli.connectTiming.handshaked = time.Now()
+	cli.connectTimingLock.Unlock()
 
 	ns, err := nh.Finish(fs, cli.handleFrame, cli.onDisconnect)
 	if err != nil {
@@ -0,0 +1,187 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"encoding/json"
+
+	"google.golang.org/protobuf/proto"
+
+	waProto "github.com/pfthink/whatsmeow/binary/proto"
+	"github.com/pfthink/whatsmeow/types"
+	"github.com/pfthink/whatsmeow/types/events"
+)
+
+// ButtonMessageHeader is the header of a template or native flow message.
+// At most one of the fields should be set. Media must be uploaded with Client.Upload first.
+type ButtonMessageHeader struct {
+	Text     string
+	Image    *waProto.ImageMessage
+	Video    *waProto.VideoMessage
+	Document *waProto.DocumentMessage
+}
+
+// QuickReplyTemplateButton creates a template button that sends a reply with the given ID when tapped.
+func QuickReplyTemplateButton(displayText, id string) *waProto.HydratedTemplateButton {
+	return &waProto.HydratedTemplateButton{
+		HydratedButton: &waProto.HydratedTemplateButton_QuickReplyButton{
+			QuickReplyButton: &waProto.HydratedQuickReplyButton{
+				DisplayText: proto.String(displayText),
+				Id:          proto.String(id),
+			},
+		},
+	}
+}
+
+// URLTemplateButton creates a template button that opens the given URL when tapped.
+func URLTemplateButton(displayText, url string) *waProto.HydratedTemplateButton {
+	return &waProto.HydratedTemplateButton{
+		HydratedButton: &waProto.HydratedTemplateButton_UrlButton{
+			UrlButton: &waProto.HydratedURLButton{
+				DisplayText: proto.String(displayText),
+				Url:         proto.String(url),
+			},
+		},
+	}
+}
+
+// CallTemplateButton creates a template button that calls the given phone number when tapped.
+func CallTemplateButton(displayText, phoneNumber string) *waProto.HydratedTemplateButton {
+	return &waProto.HydratedTemplateButton{
+		HydratedButton: &waProto.HydratedTemplateButton_CallButton{
+			CallButton: &waProto.HydratedCallButton{
+				DisplayText: proto.String(displayText),
+				PhoneNumber: proto.String(phoneNumber),
+			},
+		},
+	}
+}
+
+// BuildTemplateMessage builds a template message with the given header, body text, footer text and buttons.
+// The buttons can be created with QuickReplyTemplateButton, URLTemplateButton and CallTemplateButton.
+//
+// The buttons are copied before their indexes are set, so the same buttons can be reused in several messages.
+func BuildTemplateMessage(header ButtonMessageHeader, body, footer string, buttons ...*waProto.HydratedTemplateButton) *waProto.Message {
+	indexedButtons := make([]*waProto.HydratedTemplateButton, len(buttons))
+	for i, button := range buttons {
+		indexedButtons[i] = proto.Clone(button).(*waProto.HydratedTemplateButton)
+		indexedButtons[i].Index = proto.Uint32(uint32(i))
+	}
+	template := &waProto.HydratedFourRowTemplate{
+		HydratedContentText: proto.String(body),
+		HydratedButtons:     indexedButtons,
+	}
+	if len(footer) > 0 {
+		template.HydratedFooterText = proto.String(footer)
+	}
+	switch {
+	case header.Image != nil:
+		template.Title = &waProto.HydratedFourRowTemplate_ImageMessage{ImageMessage: header.Image}
+	case header.Video != nil:
+		template.Title = &waProto.HydratedFourRowTemplate_VideoMessage{VideoMessage: header.Video}
+	case header.Document != nil:
+		template.Title = &waProto.HydratedFourRowTemplate_DocumentMessage{DocumentMessage: header.Document}
+	case len(header.Text) > 0:
+		template.Title = &waProto.HydratedFourRowTemplate_HydratedTitleText{HydratedTitleText: header.Text}
+	}
+	return &waProto.Message{
+		TemplateMessage: &waProto.TemplateMessage{
+			// Official clients only render the message if the template is in both fields
+			HydratedTemplate: template,
+			Format:           &waProto.TemplateMessage_HydratedFourRowTemplate{HydratedFourRowTemplate: template},
+		},
+	}
+}
+
+func nativeFlowButton(name string, params map[string]string) *waProto.NativeFlowButton {
+	paramsJSON, _ := json.Marshal(params)
+	return &waProto.NativeFlowButton{
+		Name:             proto.String(name),
+		ButtonParamsJson: proto.String(string(paramsJSON)),
+	}
+}
+
+// QuickReplyNativeFlowButton creates a native flow button that sends a reply with the given ID when tapped.
+func QuickReplyNativeFlowButton(displayText, id string) *waProto.NativeFlowButton {
+	return nativeFlowButton("quick_reply", map[string]string{"display_text": displayText, "id": id})
+}
+
+// URLNativeFlowButton creates a native flow button that opens the given URL when tapped.
+func URLNativeFlowButton(displayText, url string) *waProto.NativeFlowButton {
+	return nativeFlowButton("cta_url", map[string]string{"display_text": displayText, "url": url, "merchant_url": url})
+}
+
+// CallNativeFlowButton creates a native flow button that calls the given phone number when tapped.
+func CallNativeFlowButton(displayText, phoneNumber string) *waProto.NativeFlowButton {
+	return nativeFlowButton("cta_call", map[string]string{"display_text": displayText, "phone_number": phoneNumber})
+}
+
+// BuildNativeFlowMessage builds an interactive message with the given header, body text, footer text and
+// native flow buttons. The buttons can be created with QuickReplyNativeFlowButton, URLNativeFlowButton and
+// CallNativeFlowButton, or manually for other native flow types.
+func BuildNativeFlowMessage(header ButtonMessageHeader, body, footer string, buttons ...*waProto.NativeFlowButton) *waProto.Message {
+	msg := &waProto.InteractiveMessage{
+		Body: &waProto.InteractiveMessageBody{Text: proto.String(body)},
+		InteractiveMessage: &waProto.InteractiveMessage_NativeFlowMessage{
+			NativeFlowMessage: &waProto.NativeFlowMessage{
+				Buttons:        buttons,
+				MessageVersion: proto.Int32(1),
+			},
+		},
+	}
+	if len(footer) > 0 {
+		msg.Footer = &waProto.Footer{Text: proto.String(footer)}
+	}
+	msgHeader := &waProto.Header{Title: proto.String(header.Text)}
+	switch {
+	case header.Image != nil:
+		msgHeader.Media = &waProto.Header_ImageMessage{ImageMessage: header.Image}
+	case header.Video != nil:
+		msgHeader.Media = &waProto.Header_VideoMessage{VideoMessage: header.Video}
+	case header.Document != nil:
+		msgHeader.Media = &waProto.Header_DocumentMessage{DocumentMessage: header.Document}
+	}
+	msgHeader.HasMediaAttachment = proto.Bool(msgHeader.Media != nil)
+	msg.Header = msgHeader
+	return &waProto.Message{InteractiveMessage: msg}
+}
+
+func parseButtonResponse(info *types.MessageInfo, msg *waProto.Message) *events.ButtonResponse {
+	evt := &events.ButtonResponse{Info: *info}
+	switch {
+	case msg.GetButtonsResponseMessage() != nil:
+		resp := msg.GetButtonsResponseMessage()
+		evt.Type = events.ButtonResponseTypeButtons
+		evt.OriginalMessageID = resp.GetContextInfo().GetStanzaId()
+		evt.SelectedID = resp.GetSelectedButtonId()
+		evt.SelectedDisplayText = resp.GetSelectedDisplayText()
+	case msg.GetTemplateButtonReplyMessage() != nil:
+		resp := msg.GetTemplateButtonReplyMessage()
+		evt.Type = events.ButtonResponseTypeTemplate
+		evt.OriginalMessageID = resp.GetContextInfo().GetStanzaId()
+		evt.SelectedID = resp.GetSelectedId()
+		evt.SelectedDisplayText = resp.GetSelectedDisplayText()
+		evt.SelectedIndex = int(resp.GetSelectedIndex())
+	case msg.GetInteractiveResponseMessage().GetNativeFlowResponseMessage() != nil:
+		resp := msg.GetInteractiveResponseMessage()
+		nativeFlow := resp.GetNativeFlowResponseMessage()
+		evt.Type = events.ButtonResponseTypeNativeFlow
+		evt.OriginalMessageID = resp.GetContextInfo().GetStanzaId()
+		evt.SelectedDisplayText = resp.GetBody().GetText()
+		evt.NativeFlowName = nativeFlow.GetName()
+		evt.NativeFlowParamsJSON = nativeFlow.GetParamsJson()
+		var params struct {
+			ID string `json:"id"`
+		}
+		if json.Unmarshal([]byte(nativeFlow.GetParamsJson()), &params) == nil {
+			evt.SelectedID = params.ID
+		}
+	default:
+		return nil
+	}
+	return evt
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"testing"
+
+	waProto "github.com/pfthink/whatsmeow/binary/proto"
+)
+
+func TestBuildTemplateMessageDoesntModifyButtons(t *testing.T) {
+	yes := QuickReplyTemplateButton("Yes", "yes")
+	no := QuickReplyTemplateButton("No", "no")
+	site := URLTemplateButton("Website", "https://example.com")
+
+	first := BuildTemplateMessage(ButtonMessageHeader{Text: "Title"}, "Body", "", yes, no, site)
+	// Reusing the buttons in a different order must not change the indexes in the first message
+	second := BuildTemplateMessage(ButtonMessageHeader{}, "Body", "Footer", site, yes)
+
+	for _, button := range []*waProto.HydratedTemplateButton{yes, no, site} {
+		if button.Index != nil {
+			t.Errorf("Caller's button %v was modified", button)
+		}
+	}
+	checkIndexes := func(msg *waProto.Message, expectedIDs []string) {
+		buttons := msg.GetTemplateMessage().GetHydratedTemplate().GetHydratedButtons()
+		if len(buttons) != len(expectedIDs) {
+			t.Fatalf("Expected %d buttons, got %d", len(expectedIDs), len(buttons))
+		}
+		for i, button := range buttons {
+			if button.GetIndex() != uint32(i) {
+				t.Errorf("Expected button %d to have index %d, got %d", i, i, button.GetIndex())
+			}
+			id := button.GetQuickReplyButton().GetId()
+			if id == "" {
+				id = button.GetUrlButton().GetUrl()
+			}
+			if id != expectedIDs[i] {
+				t.Errorf("Expected button %d to be %q, got %q", i, expectedIDs[i], id)
+			}
+		}
+	}
+	checkIndexes(first, []string{"yes", "no", "https://example.com"})
+	checkIndexes(second, []string{"https://example.com", "yes"})
+	if second.GetTemplateMessage().GetHydratedFourRowTemplate() != second.GetTemplateMessage().GetHydratedTemplate() {
+		t.Error("Template isn't in both fields")
+	}
+}
@@ -76,3 +76,26 @@ func (cli *Client) sendKeepAlive(ctx context.Context) (isSuccess, shouldContinue
 		return false, false
 	}
 }
+
+// Ping sends a ping to the server and waits for the response. It can be used as a health check to make sure
+// the connection is actually alive, rather than just checking IsConnected.
+//
+// The given context can be used to set a deadline for the response. If the context doesn't have a deadline,
+// KeepAliveResponseDeadline is used as the timeout. Unlike normal info queries, the ping is not retried after
+// a reconnection, as that would hide the fact that the connection was dead.
+func (cli *Client) Ping(ctx context.Context) error {
+	timeout := KeepAliveResponseDeadline
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	_, err := cli.sendIQ(infoQuery{
+		Namespace: "w:p",
+		Type:      "get",
+		To:        types.ServerJID,
+		Content:   []waBinary.Node{{Tag: "ping"}},
+		Timeout:   timeout,
+		NoRetry:   true,
+		Context:   ctx,
+	})
+	return err
+}
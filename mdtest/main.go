@@ -589,7 +589,7 @@ func handler(rawEvt interface{}, newCli *whatsmeow.Client) {
 
 		img := evt.Message.GetImageMessage()
 		if img != nil {
-			data, err := cli.Download(img)
+			data, err := cli.Download(context.Background(), img)
 			if err != nil {
 				log.Errorf("Failed to download image: %v", err)
 				return
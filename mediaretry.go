@@ -70,7 +70,7 @@ func encryptMediaRetryReceipt(messageID types.MessageID, mediaKey []byte) (ciphe
 //
 //   evt, err := cli.ParseWebMessage(chatJID, historyMsg.GetMessage())
 //   imageMsg := evt.Message.GetImageMessage() // replace this with the part of the message you want to download
-//   data, err := cli.Download(imageMsg)
+//   data, err := cli.Download(ctx, imageMsg)
 //   if errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith404) || errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith410) {
 //     err = cli.SendMediaRetryReceipt(&evt.Info, imageMsg.GetMediaKey())
 //     // You need to store the event data somewhere as it's necessary for handling the retry response.
@@ -91,7 +91,7 @@ func encryptMediaRetryReceipt(messageID types.MessageID, mediaKey []byte) (ciphe
 //       }
 //       // Use the new path to download the attachment
 //       imageMsg.DirectPath = retryData.DirectPath
-//       data, err := cli.Download(imageMsg)
+//       data, err := cli.Download(ctx, imageMsg)
 //       // Alternatively, you can use cli.DownloadMediaWithPath and provide the individual fields manually.
 //     }
 //   }
@@ -192,11 +192,11 @@ func parseMediaRetryNotification(node *waBinary.Node) (*events.MediaRetry, error
 	return &evt, nil
 }
 
-func (cli *Client) handleMediaRetryNotification(node *waBinary.Node) {
+func (cli *Client) handleMediaRetryNotification(node *waBinary.Node, meta *events.EventMeta) {
 	evt, err := parseMediaRetryNotification(node)
 	if err != nil {
 		cli.Log.Warnf("Failed to parse media retry notification: %v", err)
 		return
 	}
-	cli.dispatchEvent(evt)
+	cli.dispatchEventWithMeta(evt, meta)
 }
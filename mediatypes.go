@@ -0,0 +1,68 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"sort"
+)
+
+// MediaTypeInfo contains the constraints of a media type that can be uploaded.
+type MediaTypeInfo struct {
+	Type MediaType
+	// The MMS type used in upload and download URLs (see GetMMSType).
+	MMSType string
+	// The maximum size of the file in bytes that official clients allow. Upload doesn't check the size,
+	// so this is only advisory. Zero means there's no known limit.
+	MaxSize int64
+	// The mime types that official clients can display inline. Upload doesn't know the mime type of the file,
+	// so this is only advisory. Empty means any mime type is allowed.
+	AllowedMimeTypes []string
+	// Whether the message type has a JPEGThumbnail field. Thumbnails are never strictly required, but official
+	// clients show a blank preview until the full file is downloaded if there's no thumbnail.
+	SupportsThumbnail bool
+}
+
+var mediaTypeLimits = map[MediaType]MediaTypeInfo{
+	MediaImage: {
+		MaxSize:           16 * 1024 * 1024,
+		AllowedMimeTypes:  []string{"image/jpeg", "image/png", "image/webp"},
+		SupportsThumbnail: true,
+	},
+	MediaVideo: {
+		MaxSize:           16 * 1024 * 1024,
+		AllowedMimeTypes:  []string{"video/mp4", "video/3gpp"},
+		SupportsThumbnail: true,
+	},
+	MediaAudio: {
+		MaxSize:          16 * 1024 * 1024,
+		AllowedMimeTypes: []string{"audio/ogg; codecs=opus", "audio/mpeg", "audio/mp4", "audio/aac", "audio/amr"},
+	},
+	MediaDocument: {
+		MaxSize:           100 * 1024 * 1024,
+		SupportsThumbnail: true,
+	},
+}
+
+// MediaTypes returns all the media types that Upload accepts, along with their constraints, sorted by type.
+//
+// This includes the internal types (like MediaHistory) and any types added with RegisterMMSType.
+// The size limits are the ones official clients use, but Upload doesn't enforce them. Stickers are uploaded
+// as MediaImage, but must be WebP images.
+func MediaTypes() []MediaTypeInfo {
+	mmsTypes := GetMMSTypes()
+	infos := make([]MediaTypeInfo, 0, len(mmsTypes))
+	for mediaType, mmsType := range mmsTypes {
+		info := mediaTypeLimits[mediaType]
+		info.Type = mediaType
+		info.MMSType = mmsType
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Type < infos[j].Type
+	})
+	return infos
+}
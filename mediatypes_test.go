@@ -0,0 +1,46 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestMediaTypesMatchesUploadTypes(t *testing.T) {
+	customType := MediaType("WhatsApp Test Keys")
+	RegisterMMSType(customType, "test")
+	t.Cleanup(func() {
+		mediaTypeToMMSTypeLock.Lock()
+		delete(mediaTypeToMMSType, customType)
+		mediaTypeToMMSTypeLock.Unlock()
+	})
+
+	infos := MediaTypes()
+	mmsTypes := GetMMSTypes()
+	if len(infos) != len(mmsTypes) {
+		t.Fatalf("Expected %d media types, got %d", len(mmsTypes), len(infos))
+	}
+	if !sort.SliceIsSorted(infos, func(i, j int) bool { return infos[i].Type < infos[j].Type }) {
+		t.Error("Media types aren't sorted")
+	}
+	found := make(map[MediaType]MediaTypeInfo, len(infos))
+	for _, info := range infos {
+		if info.MMSType != mmsTypes[info.Type] {
+			t.Errorf("Expected MMS type %q for %s, got %q", mmsTypes[info.Type], info.Type, info.MMSType)
+		}
+		found[info.Type] = info
+	}
+	if info, ok := found[customType]; !ok {
+		t.Error("Registered media type is missing")
+	} else if info.MaxSize != 0 {
+		t.Errorf("Registered media type has unexpected size limit %d", info.MaxSize)
+	}
+	if found[MediaImage].MaxSize == 0 || found[MediaDocument].MaxSize == 0 {
+		t.Error("Known media types are missing size limits")
+	}
+}
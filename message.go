@@ -9,12 +9,16 @@ package whatsmeow
 import (
 	"bytes"
 	"compress/zlib"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -22,10 +26,10 @@ import (
 	"google.golang.org/protobuf/proto"
 
 	"go.mau.fi/libsignal/groups"
+	groupRecord "go.mau.fi/libsignal/groups/state/record"
 	"go.mau.fi/libsignal/protocol"
 	"go.mau.fi/libsignal/session"
 
-	"github.com/pfthink/whatsmeow/appstate"
 	waBinary "github.com/pfthink/whatsmeow/binary"
 	waProto "github.com/pfthink/whatsmeow/binary/proto"
 	"github.com/pfthink/whatsmeow/store"
@@ -35,15 +39,46 @@ import (
 
 var pbSerializer = store.SignalProtobufSerializer
 
-func (cli *Client) handleEncryptedMessage(node *waBinary.Node) {
+func (cli *Client) handleEncryptedMessage(node *waBinary.Node, meta *events.EventMeta) {
 	info, err := cli.parseMessageInfo(node)
 	if err != nil {
 		cli.Log.Warnf("Failed to parse message: %v", err)
 	} else {
 		if len(info.PushName) > 0 && info.PushName != "-" {
-			go cli.updatePushName(info.Sender, info, info.PushName)
+			go cli.updatePushName(info.Sender, info, info.PushName, meta)
 		}
-		cli.decryptMessages(info, node)
+		unlock := cli.lockSenderDecryption(info.Sender)
+		cli.decryptMessages(info, node, meta)
+		unlock()
+	}
+}
+
+type senderDecryptLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockSenderDecryption locks decryption of messages from the given sender device and returns a function
+// to unlock it. With message workers enabled, messages from the same device can be handled in parallel
+// (e.g. a DM and a group message), but they share the Signal session and sender keys of that device.
+func (cli *Client) lockSenderDecryption(sender types.JID) func() {
+	cli.senderDecryptLocksLock.Lock()
+	lock, ok := cli.senderDecryptLocks[sender]
+	if !ok {
+		lock = &senderDecryptLock{}
+		cli.senderDecryptLocks[sender] = lock
+	}
+	lock.refs++
+	cli.senderDecryptLocksLock.Unlock()
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		cli.senderDecryptLocksLock.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(cli.senderDecryptLocks, sender)
+		}
+		cli.senderDecryptLocksLock.Unlock()
 	}
 }
 
@@ -86,6 +121,7 @@ func (cli *Client) parseMessageInfo(node *waBinary.Node) (*types.MessageInfo, er
 	}
 	ag := node.AttrGetter()
 	info.ID = types.MessageID(ag.String("id"))
+	info.Type = ag.OptionalString("type")
 	info.Timestamp = ag.UnixTime("t")
 	info.PushName = ag.OptionalString("notify")
 	info.Category = ag.OptionalString("category")
@@ -104,12 +140,17 @@ func (cli *Client) parseMessageInfo(node *waBinary.Node) (*types.MessageInfo, er
 	return &info, nil
 }
 
-func (cli *Client) decryptMessages(info *types.MessageInfo, node *waBinary.Node) {
+func (cli *Client) decryptMessages(info *types.MessageInfo, node *waBinary.Node, meta *events.EventMeta) {
 	go cli.sendAck(node)
+	// Offline messages still have to be decrypted to keep the signal sessions in sync, but the events can be dropped.
+	skipEvents := cli.SkipOfflineMessageEvents && node.AttrGetter().OptionalString("offline") != ""
 	if len(node.GetChildrenByTag("unavailable")) > 0 && len(node.GetChildrenByTag("enc")) == 0 {
 		cli.Log.Warnf("Unavailable message %s from %s", info.ID, info.SourceString())
 		go cli.sendRetryReceipt(node, true)
-		cli.dispatchEvent(&events.UndecryptableMessage{Info: *info, IsUnavailable: true})
+		if skipEvents {
+			return
+		}
+		cli.dispatchEventWithMeta(&events.UndecryptableMessage{Info: *info, IsUnavailable: true}, meta)
 		return
 	}
 	children := node.GetChildren()
@@ -124,10 +165,12 @@ func (cli *Client) decryptMessages(info *types.MessageInfo, node *waBinary.Node)
 		if !ok {
 			continue
 		}
+		info.EncType = encType
+		info.DecryptVersion = child.AttrGetter().OptionalInt("v")
 		var decrypted []byte
 		var err error
 		if encType == "pkmsg" || encType == "msg" {
-			decrypted, err = cli.decryptDM(&child, info.Sender, encType == "pkmsg")
+			decrypted, err = cli.decryptDM(&child, info.Sender, encType == "pkmsg", meta)
 			containsDirectMsg = true
 		} else if info.IsGroup && encType == "skmsg" {
 			decrypted, err = cli.decryptGroupMsg(&child, info.Sender, info.Chat)
@@ -139,7 +182,9 @@ func (cli *Client) decryptMessages(info *types.MessageInfo, node *waBinary.Node)
 			cli.Log.Warnf("Error decrypting message from %s: %v", info.SourceString(), err)
 			isUnavailable := encType == "skmsg" && !containsDirectMsg && errors.Is(err, signalerror.ErrNoSenderKeyForUser)
 			go cli.sendRetryReceipt(node, isUnavailable)
-			cli.dispatchEvent(&events.UndecryptableMessage{Info: *info, IsUnavailable: isUnavailable})
+			if !skipEvents {
+				cli.dispatchEventWithMeta(&events.UndecryptableMessage{Info: *info, IsUnavailable: isUnavailable}, meta)
+			}
 			return
 		}
 
@@ -150,15 +195,20 @@ func (cli *Client) decryptMessages(info *types.MessageInfo, node *waBinary.Node)
 			continue
 		}
 
-		cli.handleDecryptedMessage(info, &msg)
+		if skipEvents {
+			cli.processProtocolParts(info, &msg, meta)
+		} else {
+			cli.handleDecryptedMessage(info, &msg, meta)
+		}
 		handled = true
 	}
-	if handled {
+	if handled && (cli.SendDeliveryReceipts || info.IsFromMe) {
+		// Sender receipts for messages from our own devices are always sent, as they're not visible to anyone else
 		go cli.sendMessageReceipt(info)
 	}
 }
 
-func (cli *Client) clearUntrustedIdentity(target types.JID) {
+func (cli *Client) clearUntrustedIdentity(target types.JID, meta *events.EventMeta) {
 	err := cli.Store.Identities.DeleteIdentity(target.SignalAddress().String())
 	if err != nil {
 		cli.Log.Warnf("Failed to delete untrusted identity of %s from store: %v", target, err)
@@ -167,10 +217,10 @@ func (cli *Client) clearUntrustedIdentity(target types.JID) {
 	if err != nil {
 		cli.Log.Warnf("Failed to delete session with %s (untrusted identity) from store: %v", target, err)
 	}
-	cli.dispatchEvent(&events.IdentityChange{JID: target, Timestamp: time.Now(), Implicit: true})
+	cli.dispatchEventWithMeta(&events.IdentityChange{JID: target, Timestamp: time.Now(), Implicit: true}, meta)
 }
 
-func (cli *Client) decryptDM(child *waBinary.Node, from types.JID, isPreKey bool) ([]byte, error) {
+func (cli *Client) decryptDM(child *waBinary.Node, from types.JID, isPreKey bool, meta *events.EventMeta) ([]byte, error) {
 	content, _ := child.Content.([]byte)
 
 	builder := session.NewBuilderFromSignal(cli.Store, from.SignalAddress(), pbSerializer)
@@ -184,7 +234,7 @@ func (cli *Client) decryptDM(child *waBinary.Node, from types.JID, isPreKey bool
 		plaintext, _, err = cipher.DecryptMessageReturnKey(preKeyMsg)
 		if cli.AutoTrustIdentity && errors.Is(err, signalerror.ErrUntrustedIdentity) {
 			cli.Log.Warnf("Got %v error while trying to decrypt prekey message from %s, clearing stored identity and retrying", err, from)
-			cli.clearUntrustedIdentity(from)
+			cli.clearUntrustedIdentity(from, meta)
 			plaintext, _, err = cipher.DecryptMessageReturnKey(preKeyMsg)
 		}
 		if err != nil {
@@ -249,7 +299,7 @@ func padMessage(plaintext []byte) []byte {
 	return plaintext
 }
 
-func (cli *Client) handleSenderKeyDistributionMessage(chat, from types.JID, rawSKDMsg *waProto.SenderKeyDistributionMessage) {
+func (cli *Client) handleSenderKeyDistributionMessage(chat, from types.JID, rawSKDMsg *waProto.SenderKeyDistributionMessage, meta *events.EventMeta) {
 	builder := groups.NewGroupSessionBuilder(cli.Store, pbSerializer)
 	senderKeyName := protocol.NewSenderKeyName(chat.String(), from.SignalAddress())
 	sdkMsg, err := protocol.NewSenderKeyDistributionMessageFromBytes(rawSKDMsg.AxolotlSenderKeyDistributionMessage, pbSerializer.SenderKeyDistributionMessage)
@@ -259,6 +309,80 @@ func (cli *Client) handleSenderKeyDistributionMessage(chat, from types.JID, rawS
 	}
 	builder.Process(senderKeyName, sdkMsg)
 	cli.Log.Debugf("Processed sender key distribution message from %s in %s", senderKeyName.Sender().String(), senderKeyName.GroupID())
+	if cli.EmitSenderKeyEvents {
+		cli.dispatchEventWithMeta(&events.SenderKeyDistribution{Chat: chat, Sender: from, KeyID: sdkMsg.ID(), Iteration: sdkMsg.Iteration()}, meta)
+	}
+}
+
+// HasSenderKey checks whether a sender key from the given group member is stored, i.e. whether
+// group messages from that member can be decrypted.
+//
+// Sender keys are per device, so the sender must be the full device JID that the messages are coming from.
+func (cli *Client) HasSenderKey(group, sender types.JID) (bool, error) {
+	rawKey, err := cli.Store.SenderKeys.GetSenderKey(group.String(), sender.SignalAddress().String())
+	if err != nil {
+		return false, fmt.Errorf("failed to get sender key: %w", err)
+	} else if rawKey == nil {
+		return false, nil
+	}
+	key, err := groupRecord.NewSenderKeyFromBytes(rawKey, pbSerializer.SenderKeyRecord, pbSerializer.SenderKeyState)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse sender key: %w", err)
+	}
+	return !key.IsEmpty(), nil
+}
+
+// GetGroupSenderKeys returns the devices whose sender keys are stored for the given group.
+// Our own device is included if we've sent messages to the group.
+func (cli *Client) GetGroupSenderKeys(group types.JID) ([]types.JID, error) {
+	addresses, err := cli.Store.SenderKeys.GetSenderKeys(group.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sender keys: %w", err)
+	}
+	senders := make([]types.JID, 0, len(addresses))
+	for _, address := range addresses {
+		sender, err := parseSignalAddress(address)
+		if err != nil {
+			cli.Log.Warnf("Failed to parse sender key address %s in %s: %v", address, group, err)
+			continue
+		}
+		senders = append(senders, sender)
+	}
+	return senders, nil
+}
+
+// ClearGroupSenderKeys deletes all stored sender keys for the given group, including our own.
+//
+// This can be used to recover a group where encryption has broken. The next message sent to the group will
+// use a freshly generated sender key that's distributed to all participants, and incoming messages that can't
+// be decrypted anymore will trigger retry receipts, which make the senders distribute their keys again.
+func (cli *Client) ClearGroupSenderKeys(group types.JID) error {
+	err := cli.Store.SenderKeys.DeleteSenderKeys(group.String())
+	if err != nil {
+		return fmt.Errorf("failed to delete sender keys: %w", err)
+	}
+	return nil
+}
+
+func parseSignalAddress(address string) (types.JID, error) {
+	sep := strings.LastIndexByte(address, ':')
+	if sep < 0 {
+		return types.JID{}, fmt.Errorf("missing device ID separator")
+	}
+	device, err := strconv.ParseUint(address[sep+1:], 10, 8)
+	if err != nil {
+		return types.JID{}, fmt.Errorf("failed to parse device ID: %w", err)
+	}
+	jid := types.JID{User: address[:sep], Device: uint8(device), Server: types.DefaultUserServer}
+	if agentSep := strings.LastIndexByte(jid.User, '_'); agentSep >= 0 {
+		agent, err := strconv.ParseUint(jid.User[agentSep+1:], 10, 8)
+		if err != nil {
+			return types.JID{}, fmt.Errorf("failed to parse agent ID: %w", err)
+		}
+		jid.Agent = uint8(agent)
+		jid.User = jid.User[:agentSep]
+	}
+	return jid, nil
 }
 
 func (cli *Client) handleHistorySyncNotificationLoop() {
@@ -283,7 +407,7 @@ func (cli *Client) handleHistorySyncNotificationLoop() {
 
 func (cli *Client) handleHistorySyncNotification(notif *waProto.HistorySyncNotification) {
 	var historySync waProto.HistorySync
-	if data, err := cli.Download(notif); err != nil {
+	if data, err := cli.Download(context.TODO(), notif); err != nil {
 		cli.Log.Errorf("Failed to download history sync data: %v", err)
 	} else if reader, err := zlib.NewReader(bytes.NewReader(data)); err != nil {
 		cli.Log.Errorf("Failed to create zlib reader for history sync data: %v", err)
@@ -299,6 +423,32 @@ func (cli *Client) handleHistorySyncNotification(notif *waProto.HistorySyncNotif
 		cli.dispatchEvent(&events.HistorySync{
 			Data: &historySync,
 		})
+		cli.dispatchHistorySyncProgress(&historySync)
+	}
+}
+
+// dispatchHistorySyncProgress is only called from the history sync handler loop, which never runs concurrently
+// with itself, so the chunk counter map doesn't need a lock.
+func (cli *Client) dispatchHistorySyncProgress(historySync *waProto.HistorySync) {
+	syncType := historySync.GetSyncType()
+	cli.historySyncChunks[syncType]++
+	received := cli.historySyncChunks[syncType]
+	isFinal := historySync.Progress == nil || historySync.GetProgress() >= 100
+	evt := &events.HistorySyncProgress{
+		Type:     syncType,
+		Received: received,
+		Progress: historySync.GetProgress(),
+	}
+	if isFinal {
+		evt.Total = received
+	}
+	cli.dispatchEvent(evt)
+	if isFinal {
+		delete(cli.historySyncChunks, syncType)
+		cli.dispatchEvent(&events.HistorySyncComplete{
+			Type:   syncType,
+			Chunks: received,
+		})
 	}
 }
 
@@ -330,7 +480,12 @@ func (cli *Client) handleAppStateSyncKeyShare(keys *waProto.AppStateSyncKeyShare
 	}
 	cli.appStateKeyRequestsLock.RUnlock()
 
-	for _, name := range appstate.AllPatchNames {
+	if atomic.CompareAndSwapUint32(&cli.appStateOnPairPending, 1, 0) {
+		// This is the first key share after pairing, so the app state can be fetched now
+		cli.fetchAppStateAfterPair()
+		return
+	}
+	for _, name := range cli.appStateCollections() {
 		err := cli.FetchAppState(name, false, onlyResyncIfNotSynced)
 		if err != nil {
 			cli.Log.Errorf("Failed to do initial fetch of app state %s: %v", name, err)
@@ -358,7 +513,7 @@ func (cli *Client) handleProtocolMessage(info *types.MessageInfo, msg *waProto.M
 	}
 }
 
-func (cli *Client) processProtocolParts(info *types.MessageInfo, msg *waProto.Message) {
+func (cli *Client) processProtocolParts(info *types.MessageInfo, msg *waProto.Message, meta *events.EventMeta) {
 	// Hopefully sender key distribution messages and protocol messages can't be inside ephemeral messages
 	if msg.GetDeviceSentMessage().GetMessage() != nil {
 		msg = msg.GetDeviceSentMessage().GetMessage()
@@ -367,7 +522,7 @@ func (cli *Client) processProtocolParts(info *types.MessageInfo, msg *waProto.Me
 		if !info.IsGroup {
 			cli.Log.Warnf("Got sender key distribution message in non-group chat from", info.Sender)
 		} else {
-			cli.handleSenderKeyDistributionMessage(info.Chat, info.Sender, msg.SenderKeyDistributionMessage)
+			cli.handleSenderKeyDistributionMessage(info.Chat, info.Sender, msg.SenderKeyDistributionMessage, meta)
 		}
 	}
 	if msg.GetProtocolMessage() != nil {
@@ -376,10 +531,40 @@ func (cli *Client) processProtocolParts(info *types.MessageInfo, msg *waProto.Me
 
 }
 
-func (cli *Client) handleDecryptedMessage(info *types.MessageInfo, msg *waProto.Message) {
-	cli.processProtocolParts(info, msg)
+func (cli *Client) handleDecryptedMessage(info *types.MessageInfo, msg *waProto.Message, meta *events.EventMeta) {
+	cli.processProtocolParts(info, msg, meta)
 	evt := &events.Message{Info: *info, RawMessage: msg}
-	cli.dispatchEvent(evt.UnwrapRaw())
+	cli.dispatchEventWithMeta(evt.UnwrapRaw(), meta)
+	if protoMsg := evt.Message.GetProtocolMessage(); protoMsg.GetType() == waProto.ProtocolMessage_REVOKE && protoMsg.GetKey() != nil {
+		cli.dispatchEventWithMeta(cli.parseMessageRevoke(info, protoMsg.GetKey()), meta)
+	} else if resp := parseButtonResponse(info, evt.Message); resp != nil {
+		cli.dispatchEventWithMeta(resp, meta)
+	}
+}
+
+func (cli *Client) parseMessageRevoke(info *types.MessageInfo, key *waProto.MessageKey) *events.MessageRevoke {
+	evt := &events.MessageRevoke{
+		Chat:      info.Chat,
+		RevokedBy: info.Sender.ToNonAD(),
+		MessageID: key.GetId(),
+		Timestamp: info.Timestamp,
+	}
+	if len(key.GetParticipant()) > 0 {
+		sender, err := types.ParseJID(key.GetParticipant())
+		if err != nil {
+			cli.Log.Warnf("Failed to parse participant %s in revoke of %s: %v", key.GetParticipant(), key.GetId(), err)
+		}
+		evt.Sender = sender.ToNonAD()
+	} else if key.GetFromMe() {
+		evt.Sender = evt.RevokedBy
+	} else if info.IsFromMe {
+		// fromMe is relative to the revoker, so in DMs the message was sent by the other party of the revoker
+		evt.Sender = info.Chat
+	} else if cli.Store.ID != nil {
+		evt.Sender = cli.Store.ID.ToNonAD()
+	}
+	evt.IsAdminRevoke = info.IsGroup && !evt.Sender.IsEmpty() && evt.Sender != evt.RevokedBy
+	return evt
 }
 
 func (cli *Client) sendProtocolMessageReceipt(id, msgType string) {
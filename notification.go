@@ -8,6 +8,7 @@ package whatsmeow
 
 import (
 	"errors"
+	"time"
 
 	"github.com/pfthink/whatsmeow/appstate"
 	waBinary "github.com/pfthink/whatsmeow/binary"
@@ -15,7 +16,7 @@ import (
 	"github.com/pfthink/whatsmeow/types/events"
 )
 
-func (cli *Client) handleEncryptNotification(node *waBinary.Node) {
+func (cli *Client) handleEncryptNotification(node *waBinary.Node, meta *events.EventMeta) {
 	from := node.AttrGetter().JID("from")
 	if from == types.ServerJID {
 		count := node.GetChildByTag("count")
@@ -40,7 +41,7 @@ func (cli *Client) handleEncryptNotification(node *waBinary.Node) {
 			cli.Log.Warnf("Failed to delete all sessions of %s from store after identity change: %v", from, err)
 		}
 		ts := node.AttrGetter().UnixTime("t")
-		cli.dispatchEvent(&events.IdentityChange{JID: from, Timestamp: ts})
+		cli.dispatchEventWithMeta(&events.IdentityChange{JID: from, Timestamp: ts}, meta)
 	} else {
 		cli.Log.Debugf("Got unknown encryption notification from server: %s", node.XMLString())
 	}
@@ -52,6 +53,9 @@ func (cli *Client) handleAppStateNotification(node *waBinary.Node) {
 		name := appstate.WAPatchName(ag.String("name"))
 		version := ag.Uint64("version")
 		cli.Log.Debugf("Got server sync notification that app state %s has updated to version %d", name, version)
+		if !cli.shouldSyncAppState(name) {
+			continue
+		}
 		err := cli.FetchAppState(name, false, false)
 		if errors.Is(err, ErrIQDisconnected) || errors.Is(err, ErrNotConnected) {
 			// There are some app state changes right before a remote logout, so stop syncing if we're disconnected.
@@ -63,7 +67,7 @@ func (cli *Client) handleAppStateNotification(node *waBinary.Node) {
 	}
 }
 
-func (cli *Client) handlePictureNotification(node *waBinary.Node) {
+func (cli *Client) handlePictureNotification(node *waBinary.Node, meta *events.EventMeta) {
 	ts := node.AttrGetter().UnixTime("t")
 	for _, child := range node.GetChildren() {
 		ag := child.AttrGetter()
@@ -85,16 +89,67 @@ func (cli *Client) handlePictureNotification(node *waBinary.Node) {
 			cli.Log.Debugf("Ignoring picture change notification with unexpected attributes: %v", ag.Error())
 			continue
 		}
-		cli.dispatchEvent(&evt)
+		cli.dispatchEventWithMeta(&evt, meta)
 	}
 }
 
-func (cli *Client) handleDeviceNotification(node *waBinary.Node) {
-	cli.userDevicesCacheLock.Lock()
-	defer cli.userDevicesCacheLock.Unlock()
+func (cli *Client) dispatchCompanionChanges(node *waBinary.Node, meta *events.EventMeta) {
+	ts := node.AttrGetter().UnixTime("t")
+	for _, child := range node.GetChildren() {
+		deviceChild, ok := child.GetOptionalChildByTag("device")
+		if !ok {
+			continue
+		}
+		jid := deviceChild.AttrGetter().JID("jid")
+		if cli.isOwnDevice(jid) {
+			continue
+		}
+		switch child.Tag {
+		case "add":
+			cli.dispatchEventWithMeta(&events.CompanionAdded{JID: jid, Timestamp: ts}, meta)
+		case "remove":
+			cli.dispatchEventWithMeta(&events.CompanionRemoved{JID: jid, Timestamp: ts}, meta)
+		}
+	}
+}
+
+func (cli *Client) isOwnDevice(jid types.JID) bool {
+	ownID := cli.Store.ID
+	return ownID != nil && jid.User == ownID.User && jid.Device == ownID.Device
+}
+
+// diffCompanionDevices returns the devices that were added to and removed from our own device list,
+// excluding the current device.
+func (cli *Client) diffCompanionDevices(oldList, newList []types.JID) (added, removed []types.JID) {
+	oldDevices := make(map[types.JID]struct{}, len(oldList))
+	for _, jid := range oldList {
+		oldDevices[jid] = struct{}{}
+	}
+	newDevices := make(map[types.JID]struct{}, len(newList))
+	for _, jid := range newList {
+		newDevices[jid] = struct{}{}
+		if _, existed := oldDevices[jid]; !existed && !cli.isOwnDevice(jid) {
+			added = append(added, jid)
+		}
+	}
+	for _, jid := range oldList {
+		if _, exists := newDevices[jid]; !exists && !cli.isOwnDevice(jid) {
+			removed = append(removed, jid)
+		}
+	}
+	return
+}
+
+func (cli *Client) handleDeviceNotification(node *waBinary.Node, meta *events.EventMeta) {
 	ag := node.AttrGetter()
 	from := ag.JID("from")
-	cached, ok := cli.userDevicesCache[from]
+	if cli.Store.ID != nil && from.User == cli.Store.ID.User {
+		cli.dispatchCompanionChanges(node, meta)
+	}
+	cli.userDevicesCacheLock.Lock()
+	defer cli.userDevicesCacheLock.Unlock()
+	cachedEntry, ok := cli.userDevicesCache[from]
+	cached := cachedEntry.devices
 	if !ok {
 		cli.Log.Debugf("No device list cached for %s, ignoring device list notification", from)
 		return
@@ -124,7 +179,7 @@ func (cli *Client) handleDeviceNotification(node *waBinary.Node) {
 		newParticipantHash := participantListHashV2(cached)
 		if newParticipantHash == deviceHash {
 			cli.Log.Debugf("%s's device list hash changed from %s to %s (%s). New hash matches", from, cachedParticipantHash, deviceHash, child.Tag)
-			cli.userDevicesCache[from] = cached
+			cli.userDevicesCache[from] = deviceCache{devices: cached, fetchedAt: time.Now()}
 		} else {
 			cli.Log.Warnf("%s's device list hash changed from %s to %s (%s). New hash doesn't match (%s)", from, cachedParticipantHash, deviceHash, child.Tag, newParticipantHash)
 			delete(cli.userDevicesCache, from)
@@ -132,10 +187,23 @@ func (cli *Client) handleDeviceNotification(node *waBinary.Node) {
 	}
 }
 
-func (cli *Client) handleOwnDevicesNotification(node *waBinary.Node) {
+func (cli *Client) handleOwnDevicesNotification(node *waBinary.Node, ts time.Time, meta *events.EventMeta) {
+	added, removed := cli.updateOwnDevicesCache(node)
+	for _, jid := range added {
+		cli.dispatchEventWithMeta(&events.CompanionAdded{JID: jid, Timestamp: ts}, meta)
+	}
+	for _, jid := range removed {
+		cli.dispatchEventWithMeta(&events.CompanionRemoved{JID: jid, Timestamp: ts}, meta)
+	}
+}
+
+// updateOwnDevicesCache replaces the cached list of our own devices with the one in an account sync notification
+// and returns the companion devices that were added and removed compared to the cached list.
+func (cli *Client) updateOwnDevicesCache(node *waBinary.Node) (added, removed []types.JID) {
 	cli.userDevicesCacheLock.Lock()
 	defer cli.userDevicesCacheLock.Unlock()
-	cached, ok := cli.userDevicesCache[cli.Store.ID.ToNonAD()]
+	cachedEntry, ok := cli.userDevicesCache[cli.Store.ID.ToNonAD()]
+	cached := cachedEntry.devices
 	if !ok {
 		cli.Log.Debugf("Ignoring own device change notification, device list not cached")
 		return
@@ -150,30 +218,86 @@ func (cli *Client) handleOwnDevicesNotification(node *waBinary.Node) {
 			newDeviceList = append(newDeviceList, jid)
 		}
 	}
+	added, removed = cli.diffCompanionDevices(cached, newDeviceList)
 	newHash := participantListHashV2(newDeviceList)
 	if newHash != expectedNewHash {
 		cli.Log.Debugf("Received own device list change notification %s -> %s, but expected hash was %s", oldHash, newHash, expectedNewHash)
 		delete(cli.userDevicesCache, cli.Store.ID.ToNonAD())
 	} else {
 		cli.Log.Debugf("Received own device list change notification %s -> %s", oldHash, newHash)
-		cli.userDevicesCache[cli.Store.ID.ToNonAD()] = newDeviceList
+		cli.userDevicesCache[cli.Store.ID.ToNonAD()] = deviceCache{devices: newDeviceList, fetchedAt: time.Now()}
+	}
+	return
+}
+
+func (cli *Client) handleContactsNotification(node *waBinary.Node, meta *events.EventMeta) {
+	ts := node.AttrGetter().UnixTime("t")
+	for _, child := range node.GetChildren() {
+		if child.Tag != "modify" {
+			cli.Log.Debugf("Unhandled contacts notification item %s", child.Tag)
+			continue
+		}
+		ag := child.AttrGetter()
+		oldJID := ag.JID("old")
+		newJID := ag.JID("new")
+		if !ag.OK() {
+			cli.Log.Warnf("Failed to parse phone number change notification: %v", ag.Error())
+			continue
+		}
+		cli.Log.Debugf("%s changed their phone number to %s", oldJID, newJID)
+		cli.userDevicesCacheLock.Lock()
+		delete(cli.userDevicesCache, oldJID)
+		cli.userDevicesCacheLock.Unlock()
+		if cli.MigrateContactOnNumberChange {
+			cli.migrateContact(oldJID, newJID)
+		}
+		cli.dispatchEventWithMeta(&events.PhoneNumberChange{OldJID: oldJID, NewJID: newJID, Timestamp: ts}, meta)
+	}
+}
+
+func (cli *Client) migrateContact(oldJID, newJID types.JID) {
+	info, err := cli.Store.Contacts.GetContact(oldJID)
+	if err != nil {
+		cli.Log.Warnf("Failed to get contact info of %s to migrate to %s: %v", oldJID, newJID, err)
+		return
+	} else if !info.Found {
+		return
+	}
+	if len(info.FullName) > 0 || len(info.FirstName) > 0 {
+		err = cli.Store.Contacts.PutContactName(newJID, info.FullName, info.FirstName)
+		if err != nil {
+			cli.Log.Warnf("Failed to migrate contact name of %s to %s: %v", oldJID, newJID, err)
+		}
+	}
+	if len(info.PushName) > 0 {
+		_, _, err = cli.Store.Contacts.PutPushName(newJID, info.PushName)
+		if err != nil {
+			cli.Log.Warnf("Failed to migrate push name of %s to %s: %v", oldJID, newJID, err)
+		}
+	}
+	if len(info.BusinessName) > 0 {
+		err = cli.Store.Contacts.PutBusinessName(newJID, info.BusinessName)
+		if err != nil {
+			cli.Log.Warnf("Failed to migrate business name of %s to %s: %v", oldJID, newJID, err)
+		}
 	}
 }
 
-func (cli *Client) handleAccountSyncNotification(node *waBinary.Node) {
+func (cli *Client) handleAccountSyncNotification(node *waBinary.Node, meta *events.EventMeta) {
+	ts := node.AttrGetter().UnixTime("t")
 	for _, child := range node.GetChildren() {
 		switch child.Tag {
 		case "privacy":
-			cli.handlePrivacySettingsNotification(&child)
+			cli.handlePrivacySettingsNotification(&child, meta)
 		case "devices":
-			cli.handleOwnDevicesNotification(&child)
+			cli.handleOwnDevicesNotification(&child, ts, meta)
 		default:
 			cli.Log.Debugf("Unhandled account sync item %s", child.Tag)
 		}
 	}
 }
 
-func (cli *Client) handleNotification(node *waBinary.Node) {
+func (cli *Client) handleNotification(node *waBinary.Node, meta *events.EventMeta) {
 	ag := node.AttrGetter()
 	notifType := ag.String("type")
 	if !ag.OK() {
@@ -182,24 +306,30 @@ func (cli *Client) handleNotification(node *waBinary.Node) {
 	go cli.sendAck(node)
 	switch notifType {
 	case "encrypt":
-		go cli.handleEncryptNotification(node)
+		go cli.handleEncryptNotification(node, meta)
 	case "server_sync":
 		go cli.handleAppStateNotification(node)
 	case "account_sync":
-		go cli.handleAccountSyncNotification(node)
+		go cli.handleAccountSyncNotification(node, meta)
 	case "devices":
-		go cli.handleDeviceNotification(node)
+		go cli.handleDeviceNotification(node, meta)
 	case "w:gp2":
-		evt, err := cli.parseGroupNotification(node)
+		evts, err := cli.parseGroupNotification(node)
 		if err != nil {
 			cli.Log.Errorf("Failed to parse group notification: %v", err)
 		} else {
-			go cli.dispatchEvent(evt)
+			go func() {
+				for _, evt := range evts {
+					cli.dispatchEventWithMeta(evt, meta)
+				}
+			}()
 		}
 	case "picture":
-		go cli.handlePictureNotification(node)
+		go cli.handlePictureNotification(node, meta)
 	case "mediaretry":
-		go cli.handleMediaRetryNotification(node)
+		go cli.handleMediaRetryNotification(node, meta)
+	case "contacts":
+		go cli.handleContactsNotification(node, meta)
 	// Other types: business, disappearing_mode, server, status, pay, psa, privacy_token
 	default:
 		cli.Log.Debugf("Unhandled notification with type %s", notifType)
@@ -0,0 +1,179 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	waBinary "github.com/pfthink/whatsmeow/binary"
+	"github.com/pfthink/whatsmeow/store"
+	"github.com/pfthink/whatsmeow/types"
+	"github.com/pfthink/whatsmeow/types/events"
+)
+
+var (
+	companionTestOwnID   = types.NewADJID("1111", 0, 3)
+	companionTestPrimary = types.NewADJID("1111", 0, 0)
+	companionTestDesktop = types.NewADJID("1111", 0, 5)
+	companionTestBrowser = types.NewADJID("1111", 0, 7)
+	companionTestTime    = time.Unix(1656000000, 0)
+)
+
+// companionChange is a CompanionAdded or CompanionRemoved event with the timestamp checked separately.
+type companionChange struct {
+	added bool
+	jid   types.JID
+}
+
+func newCompanionTestClient(t *testing.T, cachedDevices []types.JID) (*Client, func() []companionChange) {
+	ownID := companionTestOwnID
+	cli := NewClient(&store.Device{ID: &ownID}, nil)
+	if cachedDevices != nil {
+		cli.userDevicesCache[ownID.ToNonAD()] = deviceCache{devices: cachedDevices, fetchedAt: time.Now()}
+	}
+	var lock sync.Mutex
+	var changes []companionChange
+	cli.AddEventHandler(func(evt interface{}, _ *Client) {
+		var change companionChange
+		var ts time.Time
+		switch typedEvt := evt.(type) {
+		case *events.CompanionAdded:
+			change, ts = companionChange{added: true, jid: typedEvt.JID}, typedEvt.Timestamp
+		case *events.CompanionRemoved:
+			change, ts = companionChange{added: false, jid: typedEvt.JID}, typedEvt.Timestamp
+		default:
+			return
+		}
+		if !ts.Equal(companionTestTime) {
+			t.Errorf("Expected timestamp %s for %+v, got %s", companionTestTime, change, ts)
+		}
+		lock.Lock()
+		changes = append(changes, change)
+		lock.Unlock()
+	}, cli)
+	return cli, func() []companionChange {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]companionChange(nil), changes...)
+	}
+}
+
+func TestDeviceNotificationCompanionChanges(t *testing.T) {
+	deviceChange := func(tag string, jid types.JID) waBinary.Node {
+		return waBinary.Node{
+			Tag:     tag,
+			Attrs:   waBinary.Attrs{"device_hash": "2:unknown"},
+			Content: []waBinary.Node{{Tag: "device", Attrs: waBinary.Attrs{"jid": jid}}},
+		}
+	}
+	tests := []struct {
+		name     string
+		from     types.JID
+		changes  []waBinary.Node
+		expected []companionChange
+	}{
+		{
+			name:     "Companion linked",
+			from:     companionTestOwnID.ToNonAD(),
+			changes:  []waBinary.Node{deviceChange("add", companionTestDesktop)},
+			expected: []companionChange{{added: true, jid: companionTestDesktop}},
+		},
+		{
+			name:     "Companion unlinked",
+			from:     companionTestOwnID.ToNonAD(),
+			changes:  []waBinary.Node{deviceChange("remove", companionTestBrowser)},
+			expected: []companionChange{{added: false, jid: companionTestBrowser}},
+		},
+		{
+			name:    "Own device",
+			from:    companionTestOwnID.ToNonAD(),
+			changes: []waBinary.Node{deviceChange("add", companionTestOwnID)},
+		},
+		{
+			name:    "Other user's device",
+			from:    types.NewJID("2222", types.DefaultUserServer),
+			changes: []waBinary.Node{deviceChange("add", types.NewADJID("2222", 0, 4))},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cli, getChanges := newCompanionTestClient(t, nil)
+			cli.handleDeviceNotification(&waBinary.Node{
+				Tag:     "notification",
+				Attrs:   waBinary.Attrs{"from": test.from, "type": "devices", "t": "1656000000"},
+				Content: test.changes,
+			}, nil)
+			if changes := getChanges(); !reflect.DeepEqual(changes, test.expected) {
+				t.Errorf("Expected companion changes %+v, got %+v", test.expected, changes)
+			}
+		})
+	}
+}
+
+func TestAccountSyncCompanionChanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		cached   []types.JID
+		devices  []types.JID
+		expected []companionChange
+	}{
+		{
+			name:    "Companions changed while offline",
+			cached:  []types.JID{companionTestPrimary, companionTestOwnID, companionTestBrowser},
+			devices: []types.JID{companionTestPrimary, companionTestOwnID, companionTestDesktop},
+			expected: []companionChange{
+				{added: true, jid: companionTestDesktop},
+				{added: false, jid: companionTestBrowser},
+			},
+		},
+		{
+			name:    "No changes",
+			cached:  []types.JID{companionTestPrimary, companionTestOwnID},
+			devices: []types.JID{companionTestPrimary, companionTestOwnID},
+		},
+		{
+			name:    "Own device isn't a companion change",
+			cached:  []types.JID{companionTestPrimary},
+			devices: []types.JID{companionTestPrimary, companionTestOwnID},
+		},
+		{
+			name:    "Device list not cached",
+			devices: []types.JID{companionTestPrimary, companionTestOwnID, companionTestDesktop},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cli, getChanges := newCompanionTestClient(t, test.cached)
+			deviceNodes := make([]waBinary.Node, len(test.devices))
+			for i, jid := range test.devices {
+				jid.AD = false
+				deviceNodes[i] = waBinary.Node{Tag: "device", Attrs: waBinary.Attrs{"jid": jid}}
+			}
+			cli.handleAccountSyncNotification(&waBinary.Node{
+				Tag:   "notification",
+				Attrs: waBinary.Attrs{"from": types.ServerJID, "type": "account_sync", "t": "1656000000"},
+				Content: []waBinary.Node{{
+					Tag:     "devices",
+					Attrs:   waBinary.Attrs{"dhash": participantListHashV2(test.devices)},
+					Content: deviceNodes,
+				}},
+			}, nil)
+			if changes := getChanges(); !reflect.DeepEqual(changes, test.expected) {
+				t.Errorf("Expected companion changes %+v, got %+v", test.expected, changes)
+			}
+			if test.cached != nil {
+				cached := cli.userDevicesCache[companionTestOwnID.ToNonAD()].devices
+				if !reflect.DeepEqual(cached, test.devices) {
+					t.Errorf("Expected cached device list %v, got %v", test.devices, cached)
+				}
+			}
+		})
+	}
+}
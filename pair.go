@@ -13,6 +13,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/protobuf/proto"
@@ -28,20 +29,20 @@ import (
 
 const qrScanTimeout = 30 * time.Second
 
-func (cli *Client) handleIQ(node *waBinary.Node) {
+func (cli *Client) handleIQ(node *waBinary.Node, meta *events.EventMeta) {
 	children := node.GetChildren()
 	if len(children) != 1 || node.Attrs["from"] != types.ServerJID {
 		return
 	}
 	switch children[0].Tag {
 	case "pair-device":
-		cli.handlePairDevice(node)
+		cli.handlePairDevice(node, meta)
 	case "pair-success":
-		cli.handlePairSuccess(node)
+		cli.handlePairSuccess(node, meta)
 	}
 }
 
-func (cli *Client) handlePairDevice(node *waBinary.Node) {
+func (cli *Client) handlePairDevice(node *waBinary.Node, meta *events.EventMeta) {
 	pairDevice := node.GetChildByTag("pair-device")
 	err := cli.sendNode(waBinary.Node{
 		Tag: "iq",
@@ -69,7 +70,7 @@ func (cli *Client) handlePairDevice(node *waBinary.Node) {
 		evt.Codes = append(evt.Codes, cli.makeQRData(string(content)))
 	}
 
-	cli.dispatchEvent(evt)
+	cli.dispatchEventWithMeta(evt, meta)
 }
 
 func (cli *Client) makeQRData(ref string) string {
@@ -79,7 +80,7 @@ func (cli *Client) makeQRData(ref string) string {
 	return strings.Join([]string{ref, noise, identity, adv}, ",")
 }
 
-func (cli *Client) handlePairSuccess(node *waBinary.Node) {
+func (cli *Client) handlePairSuccess(node *waBinary.Node, meta *events.EventMeta) {
 	id := node.Attrs["id"].(string)
 	pairSuccess := node.GetChildByTag("pair-success")
 
@@ -93,10 +94,13 @@ func (cli *Client) handlePairSuccess(node *waBinary.Node) {
 		if err != nil {
 			cli.Log.Errorf("Failed to pair device: %v", err)
 			cli.Disconnect()
-			cli.dispatchEvent(&events.PairError{ID: jid, BusinessName: businessName, Platform: platform, Error: err})
+			cli.dispatchEventWithMeta(&events.PairError{ID: jid, BusinessName: businessName, Platform: platform, Error: err}, meta)
 		} else {
 			cli.Log.Infof("Successfully paired %s", cli.Store.ID)
-			cli.dispatchEvent(&events.PairSuccess{ID: jid, BusinessName: businessName, Platform: platform})
+			if cli.RequestAppStateOnPair {
+				atomic.StoreUint32(&cli.appStateOnPairPending, 1)
+			}
+			cli.dispatchEventWithMeta(&events.PairSuccess{ID: jid, BusinessName: businessName, Platform: platform}, meta)
 		}
 	}()
 }
@@ -124,19 +128,28 @@ func (cli *Client) handlePair(deviceIdentityBytes []byte, reqID, businessName, p
 		return fmt.Errorf("failed to parse signed device identity in pair success message: %w", err)
 	}
 
-	if !verifyDeviceIdentityAccountSignature(&deviceIdentity, cli.Store.IdentityKey) {
-		cli.sendIQError(reqID, 401, "not-authorized")
-		return fmt.Errorf("invalid device signature in pair success message")
-	}
-
-	deviceIdentity.DeviceSignature = generateDeviceSignature(&deviceIdentity, cli.Store.IdentityKey)[:]
-
 	var deviceIdentityDetails waProto.ADVDeviceIdentity
 	err = proto.Unmarshal(deviceIdentity.Details, &deviceIdentityDetails)
 	if err != nil {
 		cli.sendIQError(reqID, 500, "internal-error")
 		return fmt.Errorf("failed to parse device identity details in pair success message: %w", err)
 	}
+	clockSkew := pairClockSkew(&deviceIdentityDetails)
+
+	clockSkewed := clockSkew > maxPairClockSkew || clockSkew < -maxPairClockSkew
+	if clockSkewed {
+		cli.Log.Warnf("Local clock seems to be %s off compared to the device identity timestamp", clockSkew)
+	}
+
+	if !verifyDeviceIdentityAccountSignature(&deviceIdentity, cli.Store.IdentityKey) {
+		cli.sendIQError(reqID, 401, "not-authorized")
+		if clockSkewed {
+			return fmt.Errorf("%w: invalid device signature in pair success message (local clock is %s off)", ErrClockSkew, clockSkew)
+		}
+		return fmt.Errorf("invalid device signature in pair success message")
+	}
+
+	deviceIdentity.DeviceSignature = generateDeviceSignature(&deviceIdentity, cli.Store.IdentityKey)[:]
 
 	cli.Store.Account = proto.Clone(&deviceIdentity).(*waProto.ADVSignedDeviceIdentity)
 
@@ -194,6 +207,19 @@ func (cli *Client) handlePair(deviceIdentityBytes []byte, reqID, businessName, p
 	return nil
 }
 
+// maxPairClockSkew is the maximum difference between the local clock and the pairing timestamp before
+// the local clock is assumed to be wrong.
+const maxPairClockSkew = 1 * time.Hour
+
+// pairClockSkew returns how far ahead the local clock is compared to the timestamp in the device identity,
+// or zero if the identity doesn't have a timestamp.
+func pairClockSkew(details *waProto.ADVDeviceIdentity) time.Duration {
+	if details.GetTimestamp() == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(int64(details.GetTimestamp()), 0)).Round(time.Second)
+}
+
 func concatBytes(data ...[]byte) []byte {
 	length := 0
 	for _, item := range data {
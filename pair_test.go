@@ -0,0 +1,87 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	waProto "github.com/pfthink/whatsmeow/binary/proto"
+	"github.com/pfthink/whatsmeow/store"
+	"github.com/pfthink/whatsmeow/types"
+	"github.com/pfthink/whatsmeow/util/keys"
+)
+
+func TestPairClockSkew(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		timestamp uint64
+		expected  time.Duration
+	}{
+		{"No timestamp", 0, 0},
+		{"Local clock ahead", uint64(now.Add(-2 * time.Hour).Unix()), 2 * time.Hour},
+		{"Local clock behind", uint64(now.Add(3 * time.Hour).Unix()), -3 * time.Hour},
+		{"In sync", uint64(now.Unix()), 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			skew := pairClockSkew(&waProto.ADVDeviceIdentity{Timestamp: proto.Uint64(test.timestamp)})
+			// Allow a second of difference for the time passed since now was taken
+			if diff := skew - test.expected; diff > time.Second || diff < -time.Second {
+				t.Errorf("Expected skew of %s, got %s", test.expected, skew)
+			}
+		})
+	}
+}
+
+func TestHandlePairInvalidSignature(t *testing.T) {
+	advSecret := make([]byte, 32)
+	cli := NewClient(&store.Device{AdvSecretKey: advSecret, IdentityKey: keys.NewKeyPair()}, nil)
+	tests := []struct {
+		name        string
+		timestamp   time.Time
+		mentionSkew bool
+	}{
+		{"Clock in sync", time.Now(), false},
+		{"Clock skewed", time.Now().Add(-48 * time.Hour), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			details, _ := proto.Marshal(&waProto.ADVDeviceIdentity{Timestamp: proto.Uint64(uint64(test.timestamp.Unix()))})
+			signedIdentity, _ := proto.Marshal(&waProto.ADVSignedDeviceIdentity{
+				Details:             details,
+				AccountSignatureKey: make([]byte, 32),
+				AccountSignature:    make([]byte, 64),
+			})
+			h := hmac.New(sha256.New, advSecret)
+			h.Write(signedIdentity)
+			container, _ := proto.Marshal(&waProto.ADVSignedDeviceIdentityHMAC{Details: signedIdentity, Hmac: h.Sum(nil)})
+
+			err := cli.handlePair(container, "1", "", "", types.NewADJID("1111", 0, 1))
+			// The signature error must always be returned, the clock skew is only a hint
+			if err == nil || !strings.Contains(err.Error(), "invalid device signature") {
+				t.Fatalf("Expected invalid signature error, got %v", err)
+			}
+			if mentionsSkew := strings.Contains(err.Error(), "local clock"); mentionsSkew != test.mentionSkew {
+				t.Errorf("Expected clock skew mention to be %t, got error %q", test.mentionSkew, err)
+			}
+			if isClockSkew := errors.Is(err, ErrClockSkew); isClockSkew != test.mentionSkew {
+				t.Errorf("Expected errors.Is(err, ErrClockSkew) to be %t, got error %q", test.mentionSkew, err)
+			}
+			if cli.Store.ID != nil || cli.Store.Account != nil {
+				t.Error("Device was stored despite the invalid signature")
+			}
+		})
+	}
+}
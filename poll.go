@@ -0,0 +1,144 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	waProto "github.com/pfthink/whatsmeow/binary/proto"
+	"github.com/pfthink/whatsmeow/types"
+)
+
+// HashPollOption returns the hash of a poll option name, which is how options are referred to in PollVoteMessages.
+func HashPollOption(name string) []byte {
+	hash := sha256.Sum256([]byte(name))
+	return hash[:]
+}
+
+// PollOptionResult contains the current voters of a single poll option.
+type PollOptionResult struct {
+	Name   string
+	Voters []types.JID
+}
+
+type pollVote struct {
+	options   []string
+	timestamp int64
+}
+
+// PollAggregator keeps track of the current votes of a poll.
+//
+// Each user only has one active vote: a new vote from the same user replaces their previous one,
+// and a vote with no selected options removes it. Votes are decrypted PollVoteMessages, the aggregator
+// doesn't decrypt PollUpdateMessages by itself.
+type PollAggregator struct {
+	options []string
+	hashes  map[string]string
+	votes   map[types.JID]pollVote
+	lock    sync.RWMutex
+}
+
+// NewPollAggregator creates a new aggregator for the given poll.
+//
+// Votes only contain the hashes of option names, so options with the same name can't be told apart.
+// If the poll has duplicate option names, ErrDuplicatePollOption is returned.
+func NewPollAggregator(poll *waProto.PollCreationMessage) (*PollAggregator, error) {
+	pa := &PollAggregator{
+		options: make([]string, len(poll.GetOptions())),
+		hashes:  make(map[string]string, len(poll.GetOptions())),
+		votes:   make(map[types.JID]pollVote),
+	}
+	for i, option := range poll.GetOptions() {
+		name := option.GetOptionName()
+		hash := string(HashPollOption(name))
+		if _, exists := pa.hashes[hash]; exists {
+			return nil, fmt.Errorf("%w: %q", ErrDuplicatePollOption, name)
+		}
+		pa.options[i] = name
+		pa.hashes[hash] = name
+	}
+	return pa, nil
+}
+
+// AddVote applies a decrypted vote from the given user.
+//
+// Votes may arrive out of order, so a vote that's older than the user's current vote (based on senderTimestampMs)
+// is ignored. If the vote contains unknown option hashes, ErrUnknownPollOption is returned and the vote isn't applied.
+// Options selected more than once in the same vote are only counted once.
+func (pa *PollAggregator) AddVote(voter types.JID, vote *waProto.PollVoteMessage) error {
+	options := make([]string, 0, len(vote.GetSelectedOptions()))
+	seen := make(map[string]struct{}, len(vote.GetSelectedOptions()))
+	for _, hash := range vote.GetSelectedOptions() {
+		if len(hash) != sha256.Size {
+			return fmt.Errorf("%w (invalid hash length %d)", ErrUnknownPollOption, len(hash))
+		}
+		name, ok := pa.hashes[string(hash)]
+		if !ok {
+			return fmt.Errorf("%w %X", ErrUnknownPollOption, hash)
+		} else if _, dup := seen[name]; dup {
+			continue
+		}
+		seen[name] = struct{}{}
+		options = append(options, name)
+	}
+	voter = voter.ToNonAD()
+	pa.lock.Lock()
+	defer pa.lock.Unlock()
+	existing, ok := pa.votes[voter]
+	if ok && existing.timestamp > vote.GetSenderTimestampMs() {
+		return nil
+	}
+	if len(options) == 0 {
+		// Keep the timestamp so that older votes arriving late don't resurrect the retracted vote
+		pa.votes[voter] = pollVote{timestamp: vote.GetSenderTimestampMs()}
+	} else {
+		pa.votes[voter] = pollVote{options: options, timestamp: vote.GetSenderTimestampMs()}
+	}
+	return nil
+}
+
+// Results returns the voters of each option, in the same order as the options in the poll.
+func (pa *PollAggregator) Results() []PollOptionResult {
+	pa.lock.RLock()
+	defer pa.lock.RUnlock()
+	results := make([]PollOptionResult, len(pa.options))
+	indexes := make(map[string]int, len(pa.options))
+	for i, name := range pa.options {
+		results[i].Name = name
+		indexes[name] = i
+	}
+	for voter, vote := range pa.votes {
+		for _, option := range vote.options {
+			index := indexes[option]
+			results[index].Voters = append(results[index].Voters, voter)
+		}
+	}
+	return results
+}
+
+// Tally returns the number of votes for each option name.
+func (pa *PollAggregator) Tally() map[string]int {
+	results := pa.Results()
+	tally := make(map[string]int, len(results))
+	for _, result := range results {
+		tally[result.Name] = len(result.Voters)
+	}
+	return tally
+}
+
+// VoteOf returns the options the given user has currently selected, or nil if they haven't voted.
+func (pa *PollAggregator) VoteOf(voter types.JID) []string {
+	pa.lock.RLock()
+	defer pa.lock.RUnlock()
+	vote := pa.votes[voter.ToNonAD()]
+	if len(vote.options) == 0 {
+		return nil
+	}
+	return append([]string(nil), vote.options...)
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	waProto "github.com/pfthink/whatsmeow/binary/proto"
+	"github.com/pfthink/whatsmeow/types"
+)
+
+var (
+	pollAlice    = types.NewJID("1111", types.DefaultUserServer)
+	pollAliceAD  = types.NewADJID("1111", 0, 3)
+	pollBob      = types.NewJID("2222", types.DefaultUserServer)
+	pollTestPoll = &waProto.PollCreationMessage{
+		Name: proto.String("Lunch?"),
+		Options: []*waProto.Option{
+			{OptionName: proto.String("Pizza")},
+			{OptionName: proto.String("Sushi")},
+			{OptionName: proto.String("Salad")},
+		},
+	}
+)
+
+type pollTestVote struct {
+	voter   types.JID
+	options []string
+	ts      int64
+}
+
+func (v pollTestVote) message() *waProto.PollVoteMessage {
+	msg := &waProto.PollVoteMessage{SenderTimestampMs: proto.Int64(v.ts)}
+	for _, option := range v.options {
+		msg.SelectedOptions = append(msg.SelectedOptions, HashPollOption(option))
+	}
+	return msg
+}
+
+func TestNewPollAggregatorDuplicateOptions(t *testing.T) {
+	_, err := NewPollAggregator(&waProto.PollCreationMessage{
+		Options: []*waProto.Option{
+			{OptionName: proto.String("Yes")},
+			{OptionName: proto.String("Yes")},
+		},
+	})
+	if !errors.Is(err, ErrDuplicatePollOption) {
+		t.Errorf("Expected ErrDuplicatePollOption, got %v", err)
+	}
+}
+
+func TestPollAggregator(t *testing.T) {
+	tests := []struct {
+		name    string
+		votes   []pollTestVote
+		results map[string][]types.JID
+		voteOf  map[types.JID][]string
+	}{
+		{
+			name:    "No votes",
+			results: map[string][]types.JID{},
+			voteOf:  map[types.JID][]string{pollAlice: nil},
+		},
+		{
+			name: "Single and multiple choice",
+			votes: []pollTestVote{
+				{voter: pollAlice, options: []string{"Pizza"}, ts: 1},
+				{voter: pollBob, options: []string{"Pizza", "Salad"}, ts: 1},
+			},
+			results: map[string][]types.JID{"Pizza": {pollAlice, pollBob}, "Salad": {pollBob}},
+			voteOf:  map[types.JID][]string{pollAlice: {"Pizza"}, pollBob: {"Pizza", "Salad"}},
+		},
+		{
+			name: "New vote replaces old one",
+			votes: []pollTestVote{
+				{voter: pollAlice, options: []string{"Pizza"}, ts: 1},
+				{voter: pollAlice, options: []string{"Sushi"}, ts: 2},
+			},
+			results: map[string][]types.JID{"Sushi": {pollAlice}},
+			voteOf:  map[types.JID][]string{pollAlice: {"Sushi"}},
+		},
+		{
+			name: "Older vote arriving late is ignored",
+			votes: []pollTestVote{
+				{voter: pollAlice, options: []string{"Sushi"}, ts: 2},
+				{voter: pollAlice, options: []string{"Pizza"}, ts: 1},
+			},
+			results: map[string][]types.JID{"Sushi": {pollAlice}},
+			voteOf:  map[types.JID][]string{pollAlice: {"Sushi"}},
+		},
+		{
+			name: "Retracted vote stays retracted",
+			votes: []pollTestVote{
+				{voter: pollAlice, options: []string{"Pizza"}, ts: 1},
+				{voter: pollAlice, ts: 3},
+				{voter: pollAlice, options: []string{"Salad"}, ts: 2},
+			},
+			results: map[string][]types.JID{},
+			voteOf:  map[types.JID][]string{pollAlice: nil},
+		},
+		{
+			name: "Devices of the same user share a vote",
+			votes: []pollTestVote{
+				{voter: pollAlice, options: []string{"Pizza"}, ts: 1},
+				{voter: pollAliceAD, options: []string{"Salad"}, ts: 2},
+			},
+			results: map[string][]types.JID{"Salad": {pollAlice}},
+			voteOf:  map[types.JID][]string{pollAlice: {"Salad"}, pollAliceAD: {"Salad"}},
+		},
+		{
+			name: "Repeated option is counted once",
+			votes: []pollTestVote{
+				{voter: pollAlice, options: []string{"Pizza", "Pizza"}, ts: 1},
+			},
+			results: map[string][]types.JID{"Pizza": {pollAlice}},
+			voteOf:  map[types.JID][]string{pollAlice: {"Pizza"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pa, err := NewPollAggregator(pollTestPoll)
+			if err != nil {
+				t.Fatalf("Failed to create aggregator: %v", err)
+			}
+			for _, vote := range test.votes {
+				if err = pa.AddVote(vote.voter, vote.message()); err != nil {
+					t.Fatalf("Failed to add vote: %v", err)
+				}
+			}
+			results := pa.Results()
+			tally := pa.Tally()
+			if len(results) != len(pollTestPoll.Options) || len(tally) != len(pollTestPoll.Options) {
+				t.Fatalf("Expected a result for each of the %d options, got %d results and %d tallies", len(pollTestPoll.Options), len(results), len(tally))
+			}
+			for i, result := range results {
+				if result.Name != pollTestPoll.Options[i].GetOptionName() {
+					t.Errorf("Result %d is for %q, expected %q", i, result.Name, pollTestPoll.Options[i].GetOptionName())
+				}
+				sort.Slice(result.Voters, func(i, j int) bool { return result.Voters[i].User < result.Voters[j].User })
+				expected := test.results[result.Name]
+				if len(result.Voters) != 0 || len(expected) != 0 {
+					if !reflect.DeepEqual(result.Voters, expected) {
+						t.Errorf("Expected voters %v for %q, got %v", expected, result.Name, result.Voters)
+					}
+				}
+				if tally[result.Name] != len(expected) {
+					t.Errorf("Expected tally %d for %q, got %d", len(expected), result.Name, tally[result.Name])
+				}
+			}
+			for voter, expected := range test.voteOf {
+				if vote := pa.VoteOf(voter); !reflect.DeepEqual(vote, expected) {
+					t.Errorf("Expected vote of %s to be %v, got %v", voter, expected, vote)
+				}
+			}
+		})
+	}
+}
+
+func TestPollAggregatorUnknownOption(t *testing.T) {
+	pa, err := NewPollAggregator(pollTestPoll)
+	if err != nil {
+		t.Fatalf("Failed to create aggregator: %v", err)
+	}
+	_ = pa.AddVote(pollAlice, pollTestVote{options: []string{"Pizza"}, ts: 1}.message())
+	tests := []struct {
+		name string
+		vote *waProto.PollVoteMessage
+	}{
+		{"Unknown option", pollTestVote{options: []string{"Sushi", "Burgers"}, ts: 2}.message()},
+		{"Invalid hash length", &waProto.PollVoteMessage{SelectedOptions: [][]byte{{1, 2, 3}}, SenderTimestampMs: proto.Int64(2)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := pa.AddVote(pollAlice, test.vote); !errors.Is(err, ErrUnknownPollOption) {
+				t.Errorf("Expected ErrUnknownPollOption, got %v", err)
+			}
+			// The rejected vote must not replace the existing one
+			if vote := pa.VoteOf(pollAlice); !reflect.DeepEqual(vote, []string{"Pizza"}) {
+				t.Errorf("Expected existing vote to be kept, got %v", vote)
+			}
+		})
+	}
+}
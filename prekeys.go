@@ -18,7 +18,9 @@ import (
 
 	waBinary "github.com/pfthink/whatsmeow/binary"
 	"github.com/pfthink/whatsmeow/types"
+	"github.com/pfthink/whatsmeow/types/events"
 	"github.com/pfthink/whatsmeow/util/keys"
+	"github.com/pfthink/whatsmeow/util/randutil"
 )
 
 const (
@@ -88,6 +90,47 @@ func (cli *Client) uploadPreKeys() {
 	cli.lastPreKeyUpload = time.Now()
 }
 
+// RotateSignedPreKey generates a new signed prekey, stores it and then uploads it to the server.
+//
+// The new key is saved before it's uploaded, so the server never hands out a key that isn't in the store.
+// The previous signed prekey is saved too (see store.Device.PreviousSignedPreKey), so messages that were
+// encrypted with it before the server started handing out the new one can still be decrypted.
+// If the upload fails, the old key becomes the current one again, but the new key is kept as the previous one.
+// An events.SignedPreKeyRotated is dispatched after the rotation.
+func (cli *Client) RotateSignedPreKey() error {
+	cli.uploadPreKeysLock.Lock()
+	defer cli.uploadPreKeysLock.Unlock()
+	oldKey := cli.Store.SignedPreKey
+	newKeyID := (oldKey.KeyID + 1) & 0xffffff
+	if newKeyID == 0 {
+		newKeyID = 1
+	}
+	newKey := cli.Store.IdentityKey.CreateSignedPreKeyFromReader(newKeyID, randutil.OrDefault(cli.random))
+	err := cli.Store.RotateSignedPreKey(newKey)
+	if err != nil {
+		return fmt.Errorf("failed to store new signed prekey: %w", err)
+	}
+	_, err = cli.sendIQ(infoQuery{
+		Namespace: "encrypt",
+		Type:      "set",
+		To:        types.ServerJID,
+		Content: []waBinary.Node{{
+			Tag:     "rotate",
+			Content: []waBinary.Node{preKeyToNode(newKey)},
+		}},
+	})
+	if err != nil {
+		revertErr := cli.Store.RevertSignedPreKeyRotation()
+		if revertErr != nil {
+			cli.Log.Warnf("Failed to restore signed prekey %d after upload failure: %v", oldKey.KeyID, revertErr)
+		}
+		return fmt.Errorf("failed to upload new signed prekey: %w", err)
+	}
+	cli.Log.Infof("Rotated signed prekey %d -> %d", oldKey.KeyID, newKey.KeyID)
+	cli.dispatchEvent(&events.SignedPreKeyRotated{KeyID: newKey.KeyID, PreviousKeyID: oldKey.KeyID})
+	return nil
+}
+
 type preKeyResp struct {
 	bundle *prekey.Bundle
 	err    error
@@ -0,0 +1,156 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	waBinary "github.com/pfthink/whatsmeow/binary"
+	"github.com/pfthink/whatsmeow/store"
+	"github.com/pfthink/whatsmeow/types"
+	"github.com/pfthink/whatsmeow/util/keys"
+)
+
+// savedKeys records the signed prekey IDs a recordingContainer was last asked to save.
+type savedKeys struct {
+	current, previous uint32
+}
+
+type recordingContainer struct {
+	lock  sync.Mutex
+	err   error
+	saves []savedKeys
+}
+
+func (rc *recordingContainer) PutDevice(device *store.Device) error {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	if rc.err != nil {
+		return rc.err
+	}
+	saved := savedKeys{current: device.SignedPreKey.KeyID}
+	if device.PreviousSignedPreKey != nil {
+		saved.previous = device.PreviousSignedPreKey.KeyID
+	}
+	rc.saves = append(rc.saves, saved)
+	return nil
+}
+
+func (rc *recordingContainer) DeleteDevice(*store.Device) error {
+	return nil
+}
+
+func (rc *recordingContainer) lastSave() (savedKeys, bool) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	if len(rc.saves) == 0 {
+		return savedKeys{}, false
+	}
+	return rc.saves[len(rc.saves)-1], true
+}
+
+func newRotationTestClient(t *testing.T, container *recordingContainer) *Client {
+	identity := keys.NewKeyPair()
+	cli := NewClient(&store.Device{
+		IdentityKey:  identity,
+		SignedPreKey: identity.CreateSignedPreKey(5),
+		Container:    container,
+	}, nil)
+	connectTestSocket(t, cli)
+	t.Cleanup(cli.Disconnect)
+	return cli
+}
+
+// respondToIQ waits for the next info query and answers it with the given type. Before answering,
+// it records what the container had saved at the time the query was sent.
+func respondToIQ(cli *Client, container *recordingContainer, iqType string) <-chan savedKeys {
+	savedAtUpload := make(chan savedKeys, 1)
+	go func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			cli.responseWaitersLock.Lock()
+			var id string
+			for id = range cli.responseWaiters {
+				break
+			}
+			cli.responseWaitersLock.Unlock()
+			if id == "" {
+				time.Sleep(time.Millisecond)
+				continue
+			}
+			saved, _ := container.lastSave()
+			savedAtUpload <- saved
+			resp := &waBinary.Node{Tag: "iq", Attrs: waBinary.Attrs{"id": id, "type": iqType, "from": types.ServerJID}}
+			if iqType == "error" {
+				resp.Content = []waBinary.Node{{Tag: "error", Attrs: waBinary.Attrs{"code": 500, "text": "internal-server-error"}}}
+			}
+			cli.receiveResponse(resp)
+			return
+		}
+		close(savedAtUpload)
+	}()
+	return savedAtUpload
+}
+
+func TestRotateSignedPreKeySavesBeforeUpload(t *testing.T) {
+	container := &recordingContainer{}
+	cli := newRotationTestClient(t, container)
+	savedAtUpload := respondToIQ(cli, container, "result")
+	if err := cli.RotateSignedPreKey(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := savedKeys{current: 6, previous: 5}
+	if saved, ok := <-savedAtUpload; !ok {
+		t.Fatal("Rotated key wasn't uploaded")
+	} else if saved != expected {
+		t.Errorf("Expected %+v to be saved before upload, got %+v", expected, saved)
+	}
+	if cli.Store.SignedPreKey.KeyID != 6 || cli.Store.PreviousSignedPreKey.KeyID != 5 {
+		t.Errorf("Expected current key 6 and previous key 5, got %d and %d", cli.Store.SignedPreKey.KeyID, cli.Store.PreviousSignedPreKey.KeyID)
+	}
+	if saved, _ := container.lastSave(); saved != expected {
+		t.Errorf("Expected %+v to be saved after upload, got %+v", expected, saved)
+	}
+}
+
+func TestRotateSignedPreKeyUploadFailure(t *testing.T) {
+	container := &recordingContainer{}
+	cli := newRotationTestClient(t, container)
+	respondToIQ(cli, container, "error")
+	var iqErr *IQError
+	if err := cli.RotateSignedPreKey(); !errors.As(err, &iqErr) {
+		t.Fatalf("Expected IQ error, got %v", err)
+	}
+	// The old key is current again, but the rejected key must not be forgotten
+	if cli.Store.SignedPreKey.KeyID != 5 || cli.Store.PreviousSignedPreKey == nil || cli.Store.PreviousSignedPreKey.KeyID != 6 {
+		t.Errorf("Expected current key 5 and previous key 6, got %d and %v", cli.Store.SignedPreKey.KeyID, cli.Store.PreviousSignedPreKey)
+	}
+	if saved, _ := container.lastSave(); saved != (savedKeys{current: 5, previous: 6}) {
+		t.Errorf("Reverted keys weren't saved, got %+v", saved)
+	}
+}
+
+func TestRotateSignedPreKeySaveFailure(t *testing.T) {
+	saveErr := errors.New("database is locked")
+	container := &recordingContainer{err: saveErr}
+	cli := newRotationTestClient(t, container)
+	if err := cli.RotateSignedPreKey(); !errors.Is(err, saveErr) {
+		t.Fatalf("Expected save error, got %v", err)
+	}
+	cli.responseWaitersLock.Lock()
+	pending := len(cli.responseWaiters)
+	cli.responseWaitersLock.Unlock()
+	if pending != 0 {
+		t.Error("Key was uploaded even though it couldn't be saved")
+	}
+	if cli.Store.SignedPreKey.KeyID != 5 || cli.Store.PreviousSignedPreKey != nil {
+		t.Errorf("Keys weren't restored after save failure")
+	}
+}
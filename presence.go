@@ -14,7 +14,7 @@ import (
 	"github.com/pfthink/whatsmeow/types/events"
 )
 
-func (cli *Client) handleChatState(node *waBinary.Node) {
+func (cli *Client) handleChatState(node *waBinary.Node, meta *events.EventMeta) {
 	source, err := cli.parseMessageSource(node)
 	if err != nil {
 		cli.Log.Warnf("Failed to parse chat state update: %v", err)
@@ -27,15 +27,15 @@ func (cli *Client) handleChatState(node *waBinary.Node) {
 			cli.Log.Warnf("Unrecognized chat presence state %s", child.Tag)
 		}
 		media := types.ChatPresenceMedia(child.AttrGetter().OptionalString("media"))
-		cli.dispatchEvent(&events.ChatPresence{
+		cli.dispatchEventWithMeta(&events.ChatPresence{
 			MessageSource: source,
 			State:         presence,
 			Media:         media,
-		})
+		}, meta)
 	}
 }
 
-func (cli *Client) handlePresence(node *waBinary.Node) {
+func (cli *Client) handlePresence(node *waBinary.Node, meta *events.EventMeta) {
 	var evt events.Presence
 	ag := node.AttrGetter()
 	evt.From = ag.JID("from")
@@ -52,7 +52,7 @@ func (cli *Client) handlePresence(node *waBinary.Node) {
 	if !ag.OK() {
 		cli.Log.Warnf("Error parsing presence event: %+v", ag.Errors)
 	} else {
-		cli.dispatchEvent(&evt)
+		cli.dispatchEventWithMeta(&evt, meta)
 	}
 }
 
@@ -78,7 +78,7 @@ func (cli *Client) parsePrivacySettings(privacyNode *waBinary.Node, settings *ty
 	return &evt
 }
 
-func (cli *Client) handlePrivacySettingsNotification(privacyNode *waBinary.Node) {
+func (cli *Client) handlePrivacySettingsNotification(privacyNode *waBinary.Node, meta *events.EventMeta) {
 	cli.Log.Debugf("Parsing privacy settings change notification")
 	settings, err := cli.TryFetchPrivacySettings(false)
 	if err != nil {
@@ -89,5 +89,5 @@ func (cli *Client) handlePrivacySettingsNotification(privacyNode *waBinary.Node)
 	if err == nil {
 		cli.privacySettingsCache.Store(settings)
 	}
-	cli.dispatchEvent(evt)
+	cli.dispatchEventWithMeta(evt, meta)
 }
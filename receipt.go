@@ -16,7 +16,7 @@ import (
 	"github.com/pfthink/whatsmeow/types/events"
 )
 
-func (cli *Client) handleReceipt(node *waBinary.Node) {
+func (cli *Client) handleReceipt(node *waBinary.Node, meta *events.EventMeta) {
 	receipt, err := cli.parseReceipt(node)
 	if err != nil {
 		cli.Log.Warnf("Failed to parse receipt: %v", err)
@@ -29,11 +29,98 @@ func (cli *Client) handleReceipt(node *waBinary.Node) {
 				}
 			}()
 		}
-		go cli.dispatchEvent(receipt)
+		cli.storeRecentMessageReceipt(receipt)
+		go cli.dispatchEventWithMeta(receipt, meta)
 	}
 	go cli.sendAck(node)
 }
 
+// ParticipantReceiptInfo contains the times when a single user's devices sent receipts for a message.
+// The times are zero if no receipt of that type has been received.
+type ParticipantReceiptInfo struct {
+	DeliveredAt time.Time
+	ReadAt      time.Time
+	PlayedAt    time.Time
+}
+
+// MessageInfoResponse contains the delivery status of a sent message per recipient.
+type MessageInfoResponse struct {
+	Chat         types.JID
+	ID           types.MessageID
+	Participants map[types.JID]ParticipantReceiptInfo
+}
+
+// GetMessageInfo returns the delivered/read/played status of a message sent with this client.
+//
+// There's no query for this in the protocol, so the info is collected from the receipts received while connected.
+// Only the most recently sent messages are tracked (the same ones that are kept for handling retry receipts),
+// older messages will return ErrMessageInfoNotFound.
+func (cli *Client) GetMessageInfo(chat types.JID, id types.MessageID) (MessageInfoResponse, error) {
+	key := recentMessageKey{chat, id}
+	cli.recentMessagesLock.RLock()
+	defer cli.recentMessagesLock.RUnlock()
+	if _, ok := cli.recentMessagesMap[key]; !ok {
+		return MessageInfoResponse{}, ErrMessageInfoNotFound
+	}
+	receipts := cli.recentMessageReceipts[key]
+	resp := MessageInfoResponse{
+		Chat:         chat,
+		ID:           id,
+		Participants: make(map[types.JID]ParticipantReceiptInfo, len(receipts)),
+	}
+	for user, info := range receipts {
+		resp.Participants[user] = *info
+	}
+	return resp, nil
+}
+
+func (cli *Client) storeRecentMessageReceipt(receipt *events.Receipt) {
+	if receipt.IsFromMe {
+		return
+	}
+	switch receipt.Type {
+	case events.ReceiptTypeDelivered, events.ReceiptTypeRead, events.ReceiptTypePlayed:
+	default:
+		return
+	}
+	user := receipt.Sender.ToNonAD()
+	cli.recentMessagesLock.Lock()
+	defer cli.recentMessagesLock.Unlock()
+	for _, id := range receipt.MessageIDs {
+		key := recentMessageKey{receipt.Chat, id}
+		if _, ok := cli.recentMessagesMap[key]; !ok {
+			continue
+		}
+		receipts, ok := cli.recentMessageReceipts[key]
+		if !ok {
+			receipts = make(map[types.JID]*ParticipantReceiptInfo)
+			cli.recentMessageReceipts[key] = receipts
+		}
+		info, ok := receipts[user]
+		if !ok {
+			info = &ParticipantReceiptInfo{}
+			receipts[user] = info
+		}
+		// A later receipt type implies the earlier ones, even if they weren't received separately
+		switch receipt.Type {
+		case events.ReceiptTypePlayed:
+			setIfZero(&info.PlayedAt, receipt.Timestamp)
+			fallthrough
+		case events.ReceiptTypeRead:
+			setIfZero(&info.ReadAt, receipt.Timestamp)
+			fallthrough
+		case events.ReceiptTypeDelivered:
+			setIfZero(&info.DeliveredAt, receipt.Timestamp)
+		}
+	}
+}
+
+func setIfZero(target *time.Time, value time.Time) {
+	if target.IsZero() {
+		*target = value
+	}
+}
+
 func (cli *Client) parseReceipt(node *waBinary.Node) (*events.Receipt, error) {
 	ag := node.AttrGetter()
 	source, err := cli.parseMessageSource(node)
@@ -90,11 +177,87 @@ func (cli *Client) sendAck(node *waBinary.Node) {
 	}
 }
 
+type readReceiptBatchKey struct {
+	Chat   types.JID
+	Sender types.JID
+}
+
+type readReceiptBatch struct {
+	ids       []types.MessageID
+	timestamp time.Time
+	attempts  int
+}
+
+// readReceiptBatchMaxAttempts is the number of times a batched read receipt is sent before it's dropped.
+const readReceiptBatchMaxAttempts = 5
+
 // MarkRead sends a read receipt for the given message IDs including the given timestamp as the read at time.
 //
 // The first JID parameter (chat) must always be set to the chat ID (user ID in DMs and group ID in group chats).
 // The second JID parameter (sender) must be set in group chats and must be the user ID who sent the message.
+//
+// If Client.ReadReceiptBatchWindow is set, the IDs are queued and sent together with any other IDs marked as read
+// in the same chat (from the same sender) during the window. In that case this returns immediately. If sending
+// the batched receipt fails, the IDs are queued again for the next window, and they're only dropped (with a
+// logged warning) after several failed attempts.
 func (cli *Client) MarkRead(ids []types.MessageID, timestamp time.Time, chat, sender types.JID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if cli.ReadReceiptBatchWindow <= 0 {
+		return cli.sendReadReceipt(ids, timestamp, chat, sender)
+	}
+	if chat.Server == types.DefaultUserServer {
+		sender = types.EmptyJID
+	} else {
+		sender = sender.ToNonAD()
+	}
+	cli.queueReadReceipts(readReceiptBatchKey{Chat: chat, Sender: sender}, &readReceiptBatch{ids: ids, timestamp: timestamp})
+	return nil
+}
+
+func (cli *Client) queueReadReceipts(key readReceiptBatchKey, add *readReceiptBatch) {
+	cli.readReceiptBatchesLock.Lock()
+	defer cli.readReceiptBatchesLock.Unlock()
+	batch, ok := cli.readReceiptBatches[key]
+	if !ok {
+		batch = &readReceiptBatch{}
+		cli.readReceiptBatches[key] = batch
+		time.AfterFunc(cli.ReadReceiptBatchWindow, func() {
+			cli.flushReadReceiptBatch(key)
+		})
+	}
+	batch.ids = append(batch.ids, add.ids...)
+	if add.timestamp.After(batch.timestamp) {
+		batch.timestamp = add.timestamp
+	}
+	if add.attempts > batch.attempts {
+		batch.attempts = add.attempts
+	}
+}
+
+func (cli *Client) flushReadReceiptBatch(key readReceiptBatchKey) {
+	cli.readReceiptBatchesLock.Lock()
+	batch, ok := cli.readReceiptBatches[key]
+	delete(cli.readReceiptBatches, key)
+	cli.readReceiptBatchesLock.Unlock()
+	if !ok {
+		return
+	}
+	err := cli.sendReadReceipt(batch.ids, batch.timestamp, key.Chat, key.Sender)
+	if err == nil {
+		return
+	}
+	batch.attempts++
+	if batch.attempts >= readReceiptBatchMaxAttempts {
+		cli.Log.Warnf("Failed to send batched read receipt for %d messages in %s, giving up after %d attempts: %v", len(batch.ids), key.Chat, batch.attempts, err)
+		return
+	}
+	cli.Log.Warnf("Failed to send batched read receipt for %d messages in %s, retrying: %v", len(batch.ids), key.Chat, err)
+	cli.queueReadReceipts(key, batch)
+}
+
+func (cli *Client) sendReadReceipt(ids []types.MessageID, timestamp time.Time, chat, sender types.JID) error {
 	node := waBinary.Node{
 		Tag: "receipt",
 		Attrs: waBinary.Attrs{
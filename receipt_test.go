@@ -0,0 +1,134 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	waBinary "github.com/pfthink/whatsmeow/binary"
+	"github.com/pfthink/whatsmeow/store"
+	"github.com/pfthink/whatsmeow/types"
+)
+
+// newReceiptTestClient creates a client with read receipt batching enabled that records the IDs of all read receipts it sends.
+func newReceiptTestClient(window time.Duration) (*Client, func() map[types.JID][][]types.MessageID) {
+	cli := NewClient(&store.Device{}, nil)
+	cli.ReadReceiptBatchWindow = window
+	cli.privacySettingsCache.Store(&types.PrivacySettings{})
+	var lock sync.Mutex
+	sent := make(map[types.JID][][]types.MessageID)
+	cli.OnFrameSent = func(_ []byte, node *waBinary.Node) {
+		if node == nil || node.Tag != "receipt" {
+			return
+		}
+		ids := []types.MessageID{node.AttrGetter().String("id")}
+		list := node.GetChildByTag("list")
+		for _, item := range list.GetChildren() {
+			ids = append(ids, item.AttrGetter().String("id"))
+		}
+		lock.Lock()
+		chat := node.Attrs["to"].(types.JID)
+		sent[chat] = append(sent[chat], ids)
+		lock.Unlock()
+	}
+	return cli, func() map[types.JID][][]types.MessageID {
+		lock.Lock()
+		defer lock.Unlock()
+		copied := make(map[types.JID][][]types.MessageID, len(sent))
+		for chat, receipts := range sent {
+			copied[chat] = append([][]types.MessageID(nil), receipts...)
+		}
+		return copied
+	}
+}
+
+func waitForReceipts(t *testing.T, getSent func() map[types.JID][][]types.MessageID, count int) map[types.JID][][]types.MessageID {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		sent := getSent()
+		total := 0
+		for _, receipts := range sent {
+			total += len(receipts)
+		}
+		if total >= count {
+			return sent
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d read receipts, got %v", count, getSent())
+	return nil
+}
+
+func TestMarkReadBatchWindow(t *testing.T) {
+	chatA := types.NewJID("1111", types.DefaultUserServer)
+	chatB := types.NewJID("2222", types.DefaultUserServer)
+	cli, getSent := newReceiptTestClient(100 * time.Millisecond)
+	connectTestSocket(t, cli)
+	t.Cleanup(cli.Disconnect)
+
+	now := time.Now()
+	_ = cli.MarkRead([]types.MessageID{"A1"}, now, chatA, types.EmptyJID)
+	_ = cli.MarkRead([]types.MessageID{"B1"}, now, chatB, types.EmptyJID)
+	_ = cli.MarkRead([]types.MessageID{"A2", "A3"}, now, chatA, types.EmptyJID)
+	if sent := getSent(); len(sent) != 0 {
+		t.Fatalf("Receipts were sent before the window ended: %v", sent)
+	}
+	sent := waitForReceipts(t, getSent, 2)
+	expected := map[types.JID][][]types.MessageID{
+		chatA: {{"A1", "A2", "A3"}},
+		chatB: {{"B1"}},
+	}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected receipts %v, got %v", expected, sent)
+	}
+
+	// IDs marked after the window ended go in a new receipt
+	_ = cli.MarkRead([]types.MessageID{"A4"}, now, chatA, types.EmptyJID)
+	sent = waitForReceipts(t, getSent, 3)
+	if !reflect.DeepEqual(sent[chatA], [][]types.MessageID{{"A1", "A2", "A3"}, {"A4"}}) {
+		t.Errorf("Expected a second receipt for chat A, got %v", sent[chatA])
+	}
+}
+
+func TestMarkReadBatchRequeuedOnFailure(t *testing.T) {
+	chat := types.NewJID("1111", types.DefaultUserServer)
+	cli, getSent := newReceiptTestClient(50 * time.Millisecond)
+
+	// The client isn't connected, so the first flush fails and the batch must be kept
+	_ = cli.MarkRead([]types.MessageID{"A1"}, time.Now(), chat, types.EmptyJID)
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		cli.readReceiptBatchesLock.Lock()
+		batch := cli.readReceiptBatches[readReceiptBatchKey{Chat: chat}]
+		attempts := 0
+		if batch != nil {
+			attempts = batch.attempts
+		}
+		cli.readReceiptBatchesLock.Unlock()
+		if attempts > 0 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("Failed batch wasn't re-queued")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	_ = cli.MarkRead([]types.MessageID{"A2"}, time.Now(), chat, types.EmptyJID)
+	connectTestSocket(t, cli)
+	t.Cleanup(cli.Disconnect)
+
+	sent := waitForReceipts(t, getSent, 1)[chat]
+	if len(sent) != 1 {
+		t.Fatalf("Expected one receipt, got %v", sent)
+	}
+	sort.Slice(sent[0], func(i, j int) bool { return sent[0][i] < sent[0][j] })
+	if !reflect.DeepEqual(sent[0], []types.MessageID{"A1", "A2"}) {
+		t.Errorf("Expected re-queued and new IDs in the same receipt, got %v", sent[0])
+	}
+}
@@ -42,6 +42,7 @@ func (cli *Client) addRecentMessage(to types.JID, id types.MessageID, message *w
 	key := recentMessageKey{to, id}
 	if cli.recentMessagesList[cli.recentMessagesPtr].ID != "" {
 		delete(cli.recentMessagesMap, cli.recentMessagesList[cli.recentMessagesPtr])
+		delete(cli.recentMessageReceipts, cli.recentMessagesList[cli.recentMessagesPtr])
 	}
 	cli.recentMessagesMap[key] = message
 	cli.recentMessagesList[cli.recentMessagesPtr] = key
@@ -13,6 +13,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"sort"
 	"strconv"
 	"strings"
@@ -20,6 +21,7 @@ import (
 
 	"go.mau.fi/libsignal/signalerror"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 
 	"go.mau.fi/libsignal/groups"
 	"go.mau.fi/libsignal/keys/prekey"
@@ -29,6 +31,7 @@ import (
 	waBinary "github.com/pfthink/whatsmeow/binary"
 	waProto "github.com/pfthink/whatsmeow/binary/proto"
 	"github.com/pfthink/whatsmeow/types"
+	"github.com/pfthink/whatsmeow/util/randutil"
 )
 
 // GenerateMessageID generates a random string that can be used as a message ID on WhatsApp.
@@ -36,8 +39,18 @@ import (
 //   msgID := whatsmeow.GenerateMessageID()
 //   cli.SendMessage(targetJID, msgID, &waProto.Message{...})
 func GenerateMessageID() types.MessageID {
+	return generateMessageIDFromReader(rand.Reader)
+}
+
+// GenerateMessageID generates a random message ID like the top-level GenerateMessageID function,
+// but using the randomness source set with SetRandomSource.
+func (cli *Client) GenerateMessageID() types.MessageID {
+	return generateMessageIDFromReader(randutil.OrDefault(cli.random))
+}
+
+func generateMessageIDFromReader(random io.Reader) types.MessageID {
 	id := make([]byte, 8)
-	_, err := rand.Read(id)
+	_, err := io.ReadFull(random, id)
 	if err != nil {
 		// Out of entropy
 		panic(err)
@@ -65,20 +78,26 @@ func GenerateMessageID() types.MessageID {
 //
 // For other message types, you'll have to figure it out yourself. Looking at the protobuf schema
 // in binary/proto/def.proto may be useful to find out all the allowed fields.
+//
+// Sending normal messages to your own non-AD JID is supported (the "message yourself" chat). The message is
+// encrypted for all your other linked devices (but not the current one), so it shows up on all of them.
+//
+// SendMessage is safe to call from multiple goroutines. Encrypting and writing the message are serialized
+// (signal sessions can't be used concurrently and frames must be written in order), but the calls don't
+// block each other while waiting for the server to acknowledge the message.
 func (cli *Client) SendMessage(to types.JID, id types.MessageID, message *waProto.Message) (time.Time, error) {
-	isPeerMessage := to.User == cli.Store.ID.User
+	isPeerMessage := to.User == cli.Store.ID.User && (to.AD || isPeerProtocolMessage(message))
 	if to.AD && !isPeerMessage {
 		return time.Time{}, ErrRecipientADJID
 	}
 
 	if len(id) == 0 {
-		id = GenerateMessageID()
+		id = cli.GenerateMessageID()
 	}
 
-	// Sending multiple messages at a time can cause weird issues and makes it harder to retry safely
+	// Sending multiple messages at a time can cause weird issues and makes it harder to retry safely.
+	// The lock is only held while encrypting and sending, waiting for the server response happens in parallel.
 	cli.messageSendLock.Lock()
-	defer cli.messageSendLock.Unlock()
-
 	respChan := cli.waitResponse(id)
 	// Peer message retries aren't implemented yet
 	if !isPeerMessage {
@@ -99,6 +118,7 @@ func (cli *Client) SendMessage(to types.JID, id types.MessageID, message *waProt
 	default:
 		err = fmt.Errorf("%w %s", ErrUnknownServer, to.Server)
 	}
+	cli.messageSendLock.Unlock()
 	if err != nil {
 		cli.cancelResponse(id, respChan)
 		return time.Time{}, err
@@ -123,6 +143,38 @@ func (cli *Client) SendMessage(to types.JID, id types.MessageID, message *waProt
 	return ts, nil
 }
 
+// SendCommunityAnnouncement sends the given message to the announcement group of the given community.
+//
+// The announcement group is resolved with GetCommunityAnnouncementGroup. Only community admins can send
+// announcements, so ErrNotCommunityAdmin is returned without sending anything if you're not an admin.
+func (cli *Client) SendCommunityAnnouncement(community types.JID, message *waProto.Message) (time.Time, error) {
+	if cli.Store.ID == nil {
+		return time.Time{}, ErrNotLoggedIn
+	}
+	info, err := cli.GetGroupInfo(community)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get community info: %w", err)
+	} else if !info.IsParent {
+		return time.Time{}, ErrNotCommunity
+	}
+	ownID := cli.Store.ID.ToNonAD()
+	isAdmin := false
+	for _, participant := range info.Participants {
+		if participant.JID.ToNonAD() == ownID {
+			isAdmin = participant.IsAdmin
+			break
+		}
+	}
+	if !isAdmin {
+		return time.Time{}, ErrNotCommunityAdmin
+	}
+	announcementGroup, err := cli.GetCommunityAnnouncementGroup(community)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cli.SendMessage(announcementGroup, "", message)
+}
+
 // RevokeMessage deletes the given message from everyone in the chat.
 // You can only revoke your own messages, and if the message is too old, then other users will ignore the deletion.
 //
@@ -141,6 +193,89 @@ func (cli *Client) RevokeMessage(chat types.JID, id types.MessageID) (time.Time,
 	})
 }
 
+// BuildMessageKey builds a MessageKey that refers to the given message. This is enough to reference a message in
+// reactions and revokes, so the original message doesn't need to be stored.
+//
+// Edits are not supported: the protobuf definitions in this version don't have edit messages, and none of the
+// messages that can be built here are encrypted with the message secret, so there are no variants taking one.
+func (cli *Client) BuildMessageKey(chat, sender types.JID, id types.MessageID) *waProto.MessageKey {
+	key := &waProto.MessageKey{
+		FromMe:    proto.Bool(cli.Store.ID != nil && sender.User == cli.Store.ID.User),
+		Id:        proto.String(id),
+		RemoteJid: proto.String(chat.String()),
+	}
+	if !key.GetFromMe() && chat.Server != types.DefaultUserServer {
+		key.Participant = proto.String(sender.ToNonAD().String())
+	}
+	return key
+}
+
+// BuildReaction builds a message reacting to the given message, which can be sent with SendMessage.
+// An empty reaction string removes the previous reaction.
+//
+// Only the chat, sender and ID of the target message are needed. Reactions are sent as plain ReactionMessages
+// that refer to the target by its key, so the message secret of the target isn't needed either:
+//   msg := cli.BuildReaction(evt.Info.Chat, evt.Info.Sender, evt.Info.ID, "👍")
+//   _, err := cli.SendMessage(evt.Info.Chat, "", msg)
+func (cli *Client) BuildReaction(chat, sender types.JID, id types.MessageID, reaction string) *waProto.Message {
+	return &waProto.Message{
+		ReactionMessage: &waProto.ReactionMessage{
+			Key:               cli.BuildMessageKey(chat, sender, id),
+			Text:              proto.String(reaction),
+			SenderTimestampMs: proto.Int64(time.Now().UnixMilli()),
+		},
+	}
+}
+
+// BuildForward builds a copy of the given message that can be sent to another chat with SendMessage.
+// The copy is marked as forwarded and its forwarding score is incremented, so forwarding a message that has
+// already been forwarded multiple times will cross into the "forwarded many times" state
+// (see events.FrequentlyForwardedThreshold).
+//
+// Replies and other chat-specific context are removed from the copy. Media is forwarded by reference,
+// so it doesn't need to be downloaded or uploaded again:
+//   _, err := cli.SendMessage(targetChat, "", cli.BuildForward(evt.Message))
+func (cli *Client) BuildForward(msg *waProto.Message) *waProto.Message {
+	if msg.GetDeviceSentMessage().GetMessage() != nil {
+		msg = msg.GetDeviceSentMessage().GetMessage()
+	}
+	if msg.GetEphemeralMessage().GetMessage() != nil {
+		msg = msg.GetEphemeralMessage().GetMessage()
+	}
+	msg = proto.Clone(msg).(*waProto.Message)
+	if msg.Conversation != nil {
+		// Plain text messages can't have context info, so convert them to extended text messages
+		msg.ExtendedTextMessage = &waProto.ExtendedTextMessage{Text: msg.Conversation}
+		msg.Conversation = nil
+	}
+	msg.MessageContextInfo = nil
+	msg.ProtoReflect().Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		if field.Kind() != protoreflect.MessageKind || field.IsList() || field.IsMap() {
+			return true
+		}
+		subMsg := value.Message()
+		ciField := subMsg.Descriptor().Fields().ByName("contextInfo")
+		if ciField == nil {
+			return true
+		}
+		var oldContextInfo *waProto.ContextInfo
+		if subMsg.Has(ciField) {
+			oldContextInfo, _ = subMsg.Get(ciField).Message().Interface().(*waProto.ContextInfo)
+		}
+		var score uint32
+		if oldContextInfo.GetIsForwarded() {
+			score = oldContextInfo.GetForwardingScore()
+		}
+		subMsg.Set(ciField, protoreflect.ValueOfMessage((&waProto.ContextInfo{
+			IsForwarded:     proto.Bool(true),
+			ForwardingScore: proto.Uint32(score + 1),
+			MentionedJid:    oldContextInfo.GetMentionedJid(),
+		}).ProtoReflect()))
+		return false
+	})
+	return msg
+}
+
 const (
 	DisappearingTimerOff     = time.Duration(0)
 	DisappearingTimer24Hours = 24 * time.Hour
@@ -294,7 +429,12 @@ func (cli *Client) sendDM(to types.JID, id types.MessageID, message *waProto.Mes
 		return nil, err
 	}
 
-	node, _, err := cli.prepareMessageNode(to, id, message, []types.JID{to, cli.Store.ID.ToNonAD()}, messagePlaintext, deviceSentMessagePlaintext)
+	participants := []types.JID{to, cli.Store.ID.ToNonAD()}
+	if to.User == cli.Store.ID.User {
+		// Notes to self only need to be sent to our own devices once
+		participants = participants[:1]
+	}
+	node, _, err := cli.prepareMessageNode(to, id, message, participants, messagePlaintext, deviceSentMessagePlaintext)
 	if err != nil {
 		return nil, err
 	}
@@ -305,6 +445,21 @@ func (cli *Client) sendDM(to types.JID, id types.MessageID, message *waProto.Mes
 	return data, nil
 }
 
+// isPeerProtocolMessage returns true if the given message is a protocol message that should be sent to
+// our own devices as a peer message rather than as a normal message to our own chat.
+func isPeerProtocolMessage(msg *waProto.Message) bool {
+	switch msg.GetProtocolMessage().GetType() {
+	case waProto.ProtocolMessage_APP_STATE_SYNC_KEY_REQUEST,
+		waProto.ProtocolMessage_APP_STATE_SYNC_KEY_SHARE,
+		waProto.ProtocolMessage_HISTORY_SYNC_NOTIFICATION,
+		waProto.ProtocolMessage_INITIAL_SECURITY_NOTIFICATION_SETTING_SYNC,
+		waProto.ProtocolMessage_APP_STATE_FATAL_EXCEPTION_NOTIFICATION:
+		return true
+	default:
+		return false
+	}
+}
+
 func getTypeFromMessage(msg *waProto.Message) string {
 	switch {
 	case msg.ViewOnceMessage != nil:
@@ -504,7 +659,7 @@ func (cli *Client) encryptMessageForDevice(plaintext []byte, to types.JID, bundl
 		err := builder.ProcessBundle(bundle)
 		if cli.AutoTrustIdentity && errors.Is(err, signalerror.ErrUntrustedIdentity) {
 			cli.Log.Warnf("Got %v error while trying to process prekey bundle for %s, clearing stored identity and retrying", err, to)
-			cli.clearUntrustedIdentity(to)
+			cli.clearUntrustedIdentity(to, nil)
 			err = builder.ProcessBundle(bundle)
 		}
 		if err != nil {
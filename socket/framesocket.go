@@ -10,6 +10,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"sync"
@@ -21,6 +22,7 @@ import (
 )
 
 type Proxy = func(*http.Request) (*url.URL, error)
+type Dialer = func(ctx context.Context, network, addr string) (net.Conn, error)
 
 type FrameSocket struct {
 	conn   *websocket.Conn
@@ -28,6 +30,11 @@ type FrameSocket struct {
 	cancel func()
 	log    waLog.Logger
 	lock   sync.Mutex
+	wg     sync.WaitGroup
+
+	// writeLock ensures that frames are written to the websocket one at a time, as gorilla/websocket
+	// doesn't support concurrent writers.
+	writeLock sync.Mutex
 
 	Frames       chan []byte
 	OnDisconnect func(remote bool)
@@ -35,6 +42,9 @@ type FrameSocket struct {
 
 	Header []byte
 	Proxy  Proxy
+	Dialer Dialer
+	// URL is the websocket URL to connect to. NewFrameSocket sets it to the WhatsApp web URL.
+	URL string
 
 	incomingLength int
 	receivedLength int
@@ -50,6 +60,7 @@ func NewFrameSocket(log waLog.Logger, header []byte, proxy Proxy) *FrameSocket {
 		Frames: make(chan []byte),
 
 		Proxy: proxy,
+		URL:   URL,
 	}
 }
 
@@ -99,12 +110,13 @@ func (fs *FrameSocket) Connect() error {
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	dialer := websocket.Dialer{
-		Proxy: fs.Proxy,
+		Proxy:          fs.Proxy,
+		NetDialContext: fs.Dialer,
 	}
 
 	headers := http.Header{"Origin": []string{Origin}}
-	fs.log.Debugf("Dialing %s", URL)
-	conn, _, err := dialer.Dial(URL, headers)
+	fs.log.Debugf("Dialing %s", fs.URL)
+	conn, _, err := dialer.Dial(fs.URL, headers)
 	if err != nil {
 		cancel()
 		return fmt.Errorf("couldn't dial whatsapp web websocket: %w", err)
@@ -121,10 +133,17 @@ func (fs *FrameSocket) Connect() error {
 		return nil
 	})
 
+	fs.wg.Add(1)
 	go fs.readPump(conn, ctx)
 	return nil
 }
 
+// Wait waits until the read pump goroutine of the socket has exited.
+func (fs *FrameSocket) Wait() {
+	fs.wg.Wait()
+}
+
+// SendFrame writes the given data to the websocket as a single frame. It's safe to call from multiple goroutines.
 func (fs *FrameSocket) SendFrame(data []byte) error {
 	conn := fs.conn
 	if conn == nil {
@@ -135,6 +154,9 @@ func (fs *FrameSocket) SendFrame(data []byte) error {
 		return fmt.Errorf("%w (got %d bytes, max %d bytes)", ErrFrameTooLarge, len(data), FrameMaxSize)
 	}
 
+	fs.writeLock.Lock()
+	defer fs.writeLock.Unlock()
+
 	headerLength := len(fs.Header)
 	// Whole frame is header + 3 bytes for length + data
 	wholeFrame := make([]byte, headerLength+FrameLengthSize+dataLength)
@@ -163,16 +185,20 @@ func (fs *FrameSocket) SendFrame(data []byte) error {
 	return conn.WriteMessage(websocket.BinaryMessage, wholeFrame)
 }
 
-func (fs *FrameSocket) frameComplete() {
+func (fs *FrameSocket) frameComplete(ctx context.Context) {
 	data := fs.incoming
 	fs.incoming = nil
 	fs.partialHeader = nil
 	fs.incomingLength = 0
 	fs.receivedLength = 0
-	fs.Frames <- data
+	select {
+	case fs.Frames <- data:
+	case <-ctx.Done():
+		// Nobody is going to read the frame if the socket has been closed
+	}
 }
 
-func (fs *FrameSocket) processData(msg []byte) {
+func (fs *FrameSocket) processData(ctx context.Context, msg []byte) {
 	for len(msg) > 0 {
 		// This probably doesn't happen a lot (if at all), so the code is unoptimized
 		if fs.partialHeader != nil {
@@ -188,7 +214,7 @@ func (fs *FrameSocket) processData(msg []byte) {
 				if len(msg) >= length {
 					fs.incoming = msg[:length]
 					msg = msg[length:]
-					fs.frameComplete()
+					fs.frameComplete(ctx)
 				} else {
 					fs.incoming = make([]byte, length)
 					copy(fs.incoming, msg)
@@ -203,7 +229,7 @@ func (fs *FrameSocket) processData(msg []byte) {
 			if len(fs.incoming)+len(msg) >= fs.incomingLength {
 				copy(fs.incoming[fs.receivedLength:], msg[:fs.incomingLength-fs.receivedLength])
 				msg = msg[fs.incomingLength-fs.receivedLength:]
-				fs.frameComplete()
+				fs.frameComplete(ctx)
 			} else {
 				copy(fs.incoming[fs.receivedLength:], msg)
 				fs.receivedLength += len(msg)
@@ -218,6 +244,7 @@ func (fs *FrameSocket) readPump(conn *websocket.Conn, ctx context.Context) {
 	defer func() {
 		fs.log.Debugf("Frame websocket read pump exiting %p", fs)
 		go fs.Close(0)
+		fs.wg.Done()
 	}()
 	for {
 		msgType, data, err := conn.ReadMessage()
@@ -231,6 +258,6 @@ func (fs *FrameSocket) readPump(conn *websocket.Conn, ctx context.Context) {
 			fs.log.Warnf("Got unexpected websocket message type %d", msgType)
 			continue
 		}
-		fs.processData(data)
+		fs.processData(ctx, data)
 	}
 }
@@ -26,10 +26,13 @@ type NoiseSocket struct {
 	writeLock    sync.Mutex
 	destroyed    uint32
 	stopConsumer chan struct{}
+	consumerDone chan struct{}
 }
 
 type DisconnectHandler func(socket *NoiseSocket, remote bool)
-type FrameHandler func([]byte)
+// FrameHandler is called with each decrypted frame. The raw encrypted frame is included for logging purposes.
+// If the frame couldn't be decrypted, the handler is called with a nil plaintext.
+type FrameHandler func(ciphertext, plaintext []byte)
 
 func newNoiseSocket(fs *FrameSocket, writeKey, readKey cipher.AEAD, frameHandler FrameHandler, disconnectHandler DisconnectHandler) (*NoiseSocket, error) {
 	ns := &NoiseSocket{
@@ -38,6 +41,7 @@ func newNoiseSocket(fs *FrameSocket, writeKey, readKey cipher.AEAD, frameHandler
 		readKey:      readKey,
 		onFrame:      frameHandler,
 		stopConsumer: make(chan struct{}),
+		consumerDone: make(chan struct{}),
 	}
 	fs.OnDisconnect = func(remote bool) {
 		disconnectHandler(ns, remote)
@@ -47,6 +51,7 @@ func newNoiseSocket(fs *FrameSocket, writeKey, readKey cipher.AEAD, frameHandler
 }
 
 func (ns *NoiseSocket) consumeFrames(ctx context.Context, frames <-chan []byte) {
+	defer close(ns.consumerDone)
 	ctxDone := ctx.Done()
 	for {
 		select {
@@ -80,13 +85,29 @@ func (ns *NoiseSocket) Stop(disconnect bool) {
 	}
 }
 
+// Wait waits until the goroutines of the socket (the frame consumer and the websocket read pump) have exited.
+//
+// This should only be called after Stop, otherwise it'll block until the server closes the connection.
+func (ns *NoiseSocket) Wait() {
+	<-ns.consumerDone
+	ns.fs.Wait()
+}
+
+// SendFrame encrypts and sends the given frame. It's safe to call from multiple goroutines: the frame counter
+// and the websocket write are protected by the same lock, so frames always arrive in the order of their counters.
 func (ns *NoiseSocket) SendFrame(plaintext []byte) error {
+	_, err := ns.SendFrameAndGetCiphertext(plaintext)
+	return err
+}
+
+// SendFrameAndGetCiphertext encrypts and sends the given frame, and returns the encrypted data that was sent.
+func (ns *NoiseSocket) SendFrameAndGetCiphertext(plaintext []byte) ([]byte, error) {
 	ns.writeLock.Lock()
 	ciphertext := ns.writeKey.Seal(nil, generateIV(ns.writeCounter), plaintext, nil)
 	ns.writeCounter++
 	err := ns.fs.SendFrame(ciphertext)
 	ns.writeLock.Unlock()
-	return err
+	return ciphertext, err
 }
 
 func (ns *NoiseSocket) receiveEncryptedFrame(ciphertext []byte) {
@@ -94,9 +115,10 @@ func (ns *NoiseSocket) receiveEncryptedFrame(ciphertext []byte) {
 	plaintext, err := ns.readKey.Open(nil, generateIV(count), ciphertext, nil)
 	if err != nil {
 		ns.fs.log.Warnf("Failed to decrypt frame: %v", err)
+		ns.onFrame(ciphertext, nil)
 		return
 	}
-	ns.onFrame(plaintext)
+	ns.onFrame(ciphertext, plaintext)
 }
 
 func (ns *NoiseSocket) IsConnected() bool {
@@ -0,0 +1,107 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package socket
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	waLog "github.com/pfthink/whatsmeow/util/log"
+)
+
+func TestNoiseSocketConcurrentSend(t *testing.T) {
+	const senders = 50
+	const framesPerSender = 100
+	const totalFrames = senders * framesPerSender
+
+	key := make([]byte, 32)
+	serverKey, err := newCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan error, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			received <- err
+			return
+		}
+		defer conn.Close()
+		seen := make(map[uint64]bool, totalFrames)
+		for i := uint32(0); i < totalFrames; i++ {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				received <- err
+				return
+			} else if len(msg) < FrameLengthSize {
+				t.Errorf("frame %d is too short (%d bytes)", i, len(msg))
+				continue
+			}
+			length := (int(msg[0]) << 16) + (int(msg[1]) << 8) + int(msg[2])
+			if length != len(msg)-FrameLengthSize {
+				t.Errorf("frame %d has length %d, but contains %d bytes", i, length, len(msg)-FrameLengthSize)
+				continue
+			}
+			plaintext, err := serverKey.Open(nil, generateIV(i), msg[FrameLengthSize:], nil)
+			if err != nil {
+				t.Errorf("failed to decrypt frame %d: %v", i, err)
+				continue
+			}
+			id := binary.BigEndian.Uint64(plaintext)
+			if seen[id] {
+				t.Errorf("frame %d was received twice", id)
+			}
+			seen[id] = true
+		}
+		received <- nil
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	fs := &FrameSocket{conn: conn, ctx: ctx, cancel: cancel, log: waLog.Noop, Frames: make(chan []byte)}
+	defer fs.Close(websocket.CloseNormalClosure)
+	writeKey, _ := newCipher(key)
+	readKey, _ := newCipher(key)
+	ns, err := newNoiseSocket(fs, writeKey, readKey, func(_, _ []byte) {}, func(_ *NoiseSocket, _ bool) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(senders)
+	for i := 0; i < senders; i++ {
+		go func(sender int) {
+			defer wg.Done()
+			for j := 0; j < framesPerSender; j++ {
+				// Vary the frame size so overlapping writes would be noticeable
+				plaintext := make([]byte, 8+j*10)
+				binary.BigEndian.PutUint64(plaintext, uint64(sender*framesPerSender+j))
+				if err := ns.SendFrame(plaintext); err != nil {
+					t.Errorf("failed to send frame: %v", err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err = <-received; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"go.mau.fi/libsignal/ecc"
+	"google.golang.org/protobuf/proto"
+
+	waProto "github.com/pfthink/whatsmeow/binary/proto"
+)
+
+// Errors returned by Device.VerifyAdvChain
+var (
+	ErrAdvMissingAccount          = errors.New("device doesn't have a signed device identity")
+	ErrAdvInvalidKeyLengths       = errors.New("signed device identity has invalid key or signature lengths")
+	ErrAdvInvalidAccountSignature = errors.New("invalid account signature in signed device identity")
+	ErrAdvInvalidDeviceSignature  = errors.New("invalid device signature in signed device identity")
+	ErrAdvMainIdentityMismatch    = errors.New("account signature key doesn't match stored main device identity")
+)
+
+var (
+	advAccountSignaturePrefix = []byte{6, 0}
+	advDeviceSignaturePrefix  = []byte{6, 1}
+)
+
+// VerifyAdvChain checks the stored signed device identity (the ADV chain) of this device without
+// connecting to WhatsApp.
+//
+// The account signature must be a signature of the identity details and this device's identity key
+// made with the account signature key, and the device signature must be a signature of the same
+// data plus the account signature key made with this device's identity key. If the main device's
+// identity is in the identity store, it must also match the account signature key.
+func (device *Device) VerifyAdvChain() error {
+	acc := device.Account
+	if acc == nil || device.IdentityKey == nil {
+		return ErrAdvMissingAccount
+	}
+	if len(acc.AccountSignatureKey) != 32 || len(acc.AccountSignature) != 64 || len(acc.DeviceSignature) != 64 {
+		return ErrAdvInvalidKeyLengths
+	}
+	var details waProto.ADVDeviceIdentity
+	err := proto.Unmarshal(acc.Details, &details)
+	if err != nil {
+		return fmt.Errorf("failed to parse device identity details: %w", err)
+	}
+
+	accountSigKey := ecc.NewDjbECPublicKey(*(*[32]byte)(acc.AccountSignatureKey))
+	message := bytes.Join([][]byte{advAccountSignaturePrefix, acc.Details, device.IdentityKey.Pub[:]}, nil)
+	if !ecc.VerifySignature(accountSigKey, message, *(*[64]byte)(acc.AccountSignature)) {
+		return ErrAdvInvalidAccountSignature
+	}
+
+	deviceSigKey := ecc.NewDjbECPublicKey(*device.IdentityKey.Pub)
+	message = bytes.Join([][]byte{advDeviceSignaturePrefix, acc.Details, device.IdentityKey.Pub[:], acc.AccountSignatureKey}, nil)
+	if !ecc.VerifySignature(deviceSigKey, message, *(*[64]byte)(acc.DeviceSignature)) {
+		return ErrAdvInvalidDeviceSignature
+	}
+
+	if device.ID != nil && device.Identities != nil {
+		mainDeviceJID := *device.ID
+		mainDeviceJID.Device = 0
+		trusted, err := device.Identities.IsTrustedIdentity(mainDeviceJID.SignalAddress().String(), *(*[32]byte)(acc.AccountSignatureKey))
+		if err != nil {
+			return fmt.Errorf("failed to check main device identity: %w", err)
+		} else if !trusted {
+			return ErrAdvMainIdentityMismatch
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package store
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"go.mau.fi/libsignal/ecc"
+	"google.golang.org/protobuf/proto"
+
+	waProto "github.com/pfthink/whatsmeow/binary/proto"
+	"github.com/pfthink/whatsmeow/types"
+	"github.com/pfthink/whatsmeow/util/keys"
+)
+
+type singleIdentityStore struct {
+	IdentityStore
+	address string
+	key     [32]byte
+}
+
+func (s *singleIdentityStore) IsTrustedIdentity(address string, key [32]byte) (bool, error) {
+	return address != s.address || key == s.key, nil
+}
+
+func sign(key *keys.KeyPair, parts ...[]byte) []byte {
+	sig := ecc.CalculateSignature(ecc.NewDjbECPrivateKey(*key.Priv), bytes.Join(parts, nil))
+	return sig[:]
+}
+
+// makeSignedDevice creates a device with a valid ADV chain signed by the given account key.
+func makeSignedDevice(t *testing.T, accountKey *keys.KeyPair) *Device {
+	details, err := proto.Marshal(&waProto.ADVDeviceIdentity{
+		RawId:     proto.Uint32(1234),
+		Timestamp: proto.Uint64(1650000000),
+		KeyIndex:  proto.Uint32(1),
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal device identity details: %v", err)
+	}
+	identityKey := keys.NewKeyPair()
+	acc := &waProto.ADVSignedDeviceIdentity{
+		Details:             details,
+		AccountSignatureKey: accountKey.Pub[:],
+		AccountSignature:    sign(accountKey, advAccountSignaturePrefix, details, identityKey.Pub[:]),
+	}
+	acc.DeviceSignature = sign(identityKey, advDeviceSignaturePrefix, details, identityKey.Pub[:], accountKey.Pub[:])
+	return &Device{IdentityKey: identityKey, Account: acc}
+}
+
+func TestVerifyAdvChain(t *testing.T) {
+	accountKey := keys.NewKeyPair()
+	ownID := types.NewADJID("1234567890", 0, 5)
+	mainDeviceAddress := types.NewJID("1234567890", types.DefaultUserServer).SignalAddress().String()
+
+	tests := []struct {
+		name     string
+		modify   func(device *Device)
+		expected error
+	}{
+		{"Valid", func(device *Device) {}, nil},
+		{"ValidWithMainIdentity", func(device *Device) {
+			device.ID = &ownID
+			device.Identities = &singleIdentityStore{address: mainDeviceAddress, key: *accountKey.Pub}
+		}, nil},
+		{"MissingAccount", func(device *Device) {
+			device.Account = nil
+		}, ErrAdvMissingAccount},
+		{"TamperedDetails", func(device *Device) {
+			device.Account.Details = append([]byte{}, device.Account.Details...)
+			device.Account.Details[len(device.Account.Details)-1] ^= 1
+		}, ErrAdvInvalidAccountSignature},
+		{"WrongAccountKey", func(device *Device) {
+			device.Account.AccountSignatureKey = keys.NewKeyPair().Pub[:]
+		}, ErrAdvInvalidAccountSignature},
+		{"WrongIdentityKey", func(device *Device) {
+			device.IdentityKey = keys.NewKeyPair()
+		}, ErrAdvInvalidAccountSignature},
+		{"TamperedDeviceSignature", func(device *Device) {
+			device.Account.DeviceSignature = sign(keys.NewKeyPair(), []byte("something else"))
+		}, ErrAdvInvalidDeviceSignature},
+		{"ShortAccountKey", func(device *Device) {
+			device.Account.AccountSignatureKey = device.Account.AccountSignatureKey[:31]
+		}, ErrAdvInvalidKeyLengths},
+		{"ShortAccountSignature", func(device *Device) {
+			device.Account.AccountSignature = device.Account.AccountSignature[:63]
+		}, ErrAdvInvalidKeyLengths},
+		{"LongDeviceSignature", func(device *Device) {
+			device.Account.DeviceSignature = append(device.Account.DeviceSignature, 0)
+		}, ErrAdvInvalidKeyLengths},
+		{"MainIdentityMismatch", func(device *Device) {
+			device.ID = &ownID
+			device.Identities = &singleIdentityStore{address: mainDeviceAddress, key: *keys.NewKeyPair().Pub}
+		}, ErrAdvMainIdentityMismatch},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := makeSignedDevice(t, accountKey)
+			test.modify(device)
+			if err := device.VerifyAdvChain(); !errors.Is(err, test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+}
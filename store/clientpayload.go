@@ -9,6 +9,7 @@ package store
 import (
 	"crypto/md5"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -145,6 +146,29 @@ func SetOSInfo(name string, version [3]uint32) {
 	BaseClientPayload.UserAgent.OsBuildNumber = BaseClientPayload.UserAgent.OsVersion
 }
 
+// ErrUnknownPlatformType is returned by SetPlatformType and ParsePlatformType if the platform type isn't one of the known values.
+var ErrUnknownPlatformType = errors.New("unknown device platform type")
+
+// SetPlatformType changes the platform type that is sent in DeviceProps when pairing a new device.
+//
+// The platform type only matters at pairing time, so changing it won't affect already paired devices.
+func SetPlatformType(platformType waProto.DeviceProps_DevicePropsPlatformType) error {
+	if _, ok := waProto.DeviceProps_DevicePropsPlatformType_name[int32(platformType)]; !ok {
+		return fmt.Errorf("%w %d", ErrUnknownPlatformType, platformType)
+	}
+	DeviceProps.PlatformType = platformType.Enum()
+	return nil
+}
+
+// ParsePlatformType parses a platform type name (e.g. "DESKTOP" or "chrome") into the protobuf enum value.
+func ParsePlatformType(name string) (waProto.DeviceProps_DevicePropsPlatformType, error) {
+	value, ok := waProto.DeviceProps_DevicePropsPlatformType_value[strings.ToUpper(name)]
+	if !ok {
+		return 0, fmt.Errorf("%w '%s'", ErrUnknownPlatformType, name)
+	}
+	return waProto.DeviceProps_DevicePropsPlatformType(value), nil
+}
+
 func (device *Device) getRegistrationPayload() *waProto.ClientPayload {
 	payload := proto.Clone(BaseClientPayload).(*waProto.ClientPayload)
 	regID := make([]byte, 4)
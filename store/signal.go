@@ -137,13 +137,17 @@ func (device *Device) DeleteAllSessions() {
 }
 
 func (device *Device) LoadSignedPreKey(signedPreKeyID uint32) *record.SignedPreKey {
-	if signedPreKeyID == device.SignedPreKey.KeyID {
-		return record.NewSignedPreKey(signedPreKeyID, 0, ecc.NewECKeyPair(
-			ecc.NewDjbECPublicKey(*device.SignedPreKey.Pub),
-			ecc.NewDjbECPrivateKey(*device.SignedPreKey.Priv),
-		), *device.SignedPreKey.Signature, nil)
+	key := device.SignedPreKey
+	if signedPreKeyID != key.KeyID {
+		key = device.PreviousSignedPreKey
+		if key == nil || signedPreKeyID != key.KeyID {
+			return nil
+		}
 	}
-	return nil
+	return record.NewSignedPreKey(signedPreKeyID, 0, ecc.NewECKeyPair(
+		ecc.NewDjbECPublicKey(*key.Pub),
+		ecc.NewDjbECPrivateKey(*key.Priv),
+	), *key.Signature, nil)
 }
 
 func (device *Device) LoadSignedPreKeys() []*record.SignedPreKey {
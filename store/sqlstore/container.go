@@ -7,18 +7,19 @@
 package sqlstore
 
 import (
-	"crypto/rand"
 	"database/sql"
 	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"fmt"
-	mathRand "math/rand"
+	"io"
 
 	waProto "github.com/pfthink/whatsmeow/binary/proto"
 	"github.com/pfthink/whatsmeow/store"
 	"github.com/pfthink/whatsmeow/types"
 	"github.com/pfthink/whatsmeow/util/keys"
 	waLog "github.com/pfthink/whatsmeow/util/log"
+	"github.com/pfthink/whatsmeow/util/randutil"
 )
 
 // Container is a wrapper for a SQL database that can contain multiple whatsmeow sessions.
@@ -26,6 +27,7 @@ type Container struct {
 	db      *sql.DB
 	dialect string
 	log     waLog.Logger
+	random  io.Reader
 
 	DatabaseErrorHandler func(device *store.Device, action string, attemptIndex int, err error) (retry bool)
 }
@@ -80,7 +82,8 @@ const getAllDevicesQuery = `
 SELECT jid, biz_type, registration_id, noise_key, identity_key,
        signed_pre_key, signed_pre_key_id, signed_pre_key_sig,
        adv_key, adv_details, adv_account_sig, adv_account_sig_key, adv_device_sig,
-       platform, business_name, push_name
+       platform, business_name, push_name,
+       prev_signed_pre_key, prev_signed_pre_key_id, prev_signed_pre_key_sig
 FROM whatsmeow_device
 `
 
@@ -97,24 +100,35 @@ func (c *Container) scanDevice(row scannable) (*store.Device, error) {
 	device.DatabaseErrorHandler = c.DatabaseErrorHandler
 	device.Log = c.log
 	device.SignedPreKey = &keys.PreKey{}
-	var noisePriv, identityPriv, preKeyPriv, preKeySig []byte
+	var noisePriv, identityPriv, preKeyPriv, preKeySig, prevPreKeyPriv, prevPreKeySig []byte
+	var prevPreKeyID sql.NullInt64
 	var account waProto.ADVSignedDeviceIdentity
 
 	err := row.Scan(
 		&device.ID, &device.BizType, &device.RegistrationID, &noisePriv, &identityPriv,
 		&preKeyPriv, &device.SignedPreKey.KeyID, &preKeySig,
 		&device.AdvSecretKey, &account.Details, &account.AccountSignature, &account.AccountSignatureKey, &account.DeviceSignature,
-		&device.Platform, &device.BusinessName, &device.PushName)
+		&device.Platform, &device.BusinessName, &device.PushName,
+		&prevPreKeyPriv, &prevPreKeyID, &prevPreKeySig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to scan session: %w", err)
 	} else if len(noisePriv) != 32 || len(identityPriv) != 32 || len(preKeyPriv) != 32 || len(preKeySig) != 64 {
 		return nil, ErrInvalidLength
+	} else if prevPreKeyID.Valid && (len(prevPreKeyPriv) != 32 || len(prevPreKeySig) != 64) {
+		return nil, ErrInvalidLength
 	}
 
 	device.NoiseKey = keys.NewKeyPairFromPrivateKey(*(*[32]byte)(noisePriv))
 	device.IdentityKey = keys.NewKeyPairFromPrivateKey(*(*[32]byte)(identityPriv))
 	device.SignedPreKey.KeyPair = *keys.NewKeyPairFromPrivateKey(*(*[32]byte)(preKeyPriv))
 	device.SignedPreKey.Signature = (*[64]byte)(preKeySig)
+	if prevPreKeyID.Valid {
+		device.PreviousSignedPreKey = &keys.PreKey{
+			KeyPair:   *keys.NewKeyPairFromPrivateKey(*(*[32]byte)(prevPreKeyPriv)),
+			KeyID:     uint32(prevPreKeyID.Int64),
+			Signature: (*[64]byte)(prevPreKeySig),
+		}
+	}
 	device.Account = &account
 
 	innerStore := NewSQLStore(c, *device.ID)
@@ -218,34 +232,55 @@ const (
 		INSERT INTO whatsmeow_device (jid, jid_user, biz_type, registration_id, noise_key, identity_key,
 									  signed_pre_key, signed_pre_key_id, signed_pre_key_sig,
 									  adv_key, adv_details, adv_account_sig, adv_account_sig_key, adv_device_sig,
-									  platform, business_name, push_name)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		ON DUPLICATE KEY UPDATE platform=?, business_name=?, push_name=?
+									  platform, business_name, push_name,
+									  prev_signed_pre_key, prev_signed_pre_key_id, prev_signed_pre_key_sig)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE platform=?, business_name=?, push_name=?,
+		                        signed_pre_key=?, signed_pre_key_id=?, signed_pre_key_sig=?,
+		                        prev_signed_pre_key=?, prev_signed_pre_key_id=?, prev_signed_pre_key_sig=?
 	`
 	deleteDeviceQuery = `DELETE FROM whatsmeow_device WHERE jid=?`
 )
 
+// SetRandomSource sets the randomness source used for generating keys for new devices and prekeys.
+//
+// By default, crypto/rand is used. Passing nil resets the source to the default. Sources from
+// math/rand are rejected with randutil.ErrWeakSource unless running inside a test binary.
+func (c *Container) SetRandomSource(random io.Reader) error {
+	if err := randutil.Validate(random); err != nil {
+		return err
+	}
+	c.random = random
+	return nil
+}
+
 // NewDevice creates a new device in this database.
 //
 // No data is actually stored before Save is called. However, the pairing process will automatically
 // call Save after a successful pairing, so you most likely don't need to call it yourself.
 func (c *Container) NewDevice() *store.Device {
+	random := randutil.OrDefault(c.random)
 	device := &store.Device{
 		Log:       c.log,
 		Container: c,
 
 		DatabaseErrorHandler: c.DatabaseErrorHandler,
 
-		NoiseKey:       keys.NewKeyPair(),
-		IdentityKey:    keys.NewKeyPair(),
-		RegistrationID: mathRand.Uint32(),
-		AdvSecretKey:   make([]byte, 32),
+		NoiseKey:     keys.NewKeyPairFromReader(random),
+		IdentityKey:  keys.NewKeyPairFromReader(random),
+		AdvSecretKey: make([]byte, 32),
+	}
+	var registrationID [4]byte
+	_, err := io.ReadFull(random, registrationID[:])
+	if err != nil {
+		panic(err)
 	}
-	_, err := rand.Read(device.AdvSecretKey)
+	device.RegistrationID = binary.BigEndian.Uint32(registrationID[:])
+	_, err = io.ReadFull(random, device.AdvSecretKey)
 	if err != nil {
 		panic(err)
 	}
-	device.SignedPreKey = device.IdentityKey.CreateSignedPreKey(1)
+	device.SignedPreKey = device.IdentityKey.CreateSignedPreKeyFromReader(1, random)
 	return device
 }
 
@@ -269,18 +304,25 @@ func (c *Container) PutDevice(device *store.Device) error {
 	if device.ID == nil {
 		return ErrDeviceIDMustBeSet
 	}
+	prevPreKeyPriv, prevPreKeyID, prevPreKeySig := previousSignedPreKeyValues(device)
 	_, err := c.db.Exec(insertDeviceQuery,
 		device.ID.String(), device.ID.User, device.BizType, device.RegistrationID, device.NoiseKey.Priv[:], device.IdentityKey.Priv[:],
 		device.SignedPreKey.Priv[:], device.SignedPreKey.KeyID, device.SignedPreKey.Signature[:],
 		device.AdvSecretKey, device.Account.Details, device.Account.AccountSignature, device.Account.AccountSignatureKey, device.Account.DeviceSignature,
 		device.Platform, device.BusinessName, device.PushName,
-		device.Platform, device.BusinessName, device.PushName)
+		prevPreKeyPriv, prevPreKeyID, prevPreKeySig,
+		device.Platform, device.BusinessName, device.PushName,
+		device.SignedPreKey.Priv[:], device.SignedPreKey.KeyID, device.SignedPreKey.Signature[:],
+		prevPreKeyPriv, prevPreKeyID, prevPreKeySig)
+	if err != nil {
+		return err
+	}
 
 	//save qrcode scan result
 	noiseKeyPub, identityKeyPub, advKey := baseEncodeKeys(device)
-	_, err = c.db.Exec(insertQrcodeRecord, device.ID.String(), noiseKeyPub, identityKeyPub, advKey, 1)
-	if err != nil {
-		fmt.Println("Save qrcode result fail ")
+	_, qrErr := c.db.Exec(insertQrcodeRecord, device.ID.String(), noiseKeyPub, identityKeyPub, advKey, 1)
+	if qrErr != nil {
+		c.log.Warnf("Failed to save qrcode scan result: %v", qrErr)
 	}
 
 	if !device.Initialized {
@@ -295,7 +337,14 @@ func (c *Container) PutDevice(device *store.Device) error {
 		device.ChatSettings = innerStore
 		device.Initialized = true
 	}
-	return err
+	return nil
+}
+
+func previousSignedPreKeyValues(device *store.Device) (priv []byte, keyID sql.NullInt64, sig []byte) {
+	if key := device.PreviousSignedPreKey; key != nil {
+		return key.Priv[:], sql.NullInt64{Int64: int64(key.KeyID), Valid: true}, key.Signature[:]
+	}
+	return nil, sql.NullInt64{}, nil
 }
 
 func baseEncodeKeys(device *store.Device) (nkp, ikp, ak string) {
@@ -19,6 +19,7 @@ import (
 	"github.com/pfthink/whatsmeow/store"
 	"github.com/pfthink/whatsmeow/types"
 	"github.com/pfthink/whatsmeow/util/keys"
+	"github.com/pfthink/whatsmeow/util/randutil"
 )
 
 // ErrInvalidLength is returned by some database getters if the database returned a byte array with an unexpected length.
@@ -61,6 +62,7 @@ var _ store.SessionStore = (*SQLStore)(nil)
 var _ store.PreKeyStore = (*SQLStore)(nil)
 var _ store.SenderKeyStore = (*SQLStore)(nil)
 var _ store.AppStateSyncKeyStore = (*SQLStore)(nil)
+var _ store.AppStateSyncKeyPruner = (*SQLStore)(nil)
 var _ store.AppStateStore = (*SQLStore)(nil)
 var _ store.ContactStore = (*SQLStore)(nil)
 
@@ -156,7 +158,7 @@ const (
 )
 
 func (s *SQLStore) genOnePreKey(id uint32, markUploaded bool) (*keys.PreKey, error) {
-	key := keys.NewPreKey(id)
+	key := keys.NewPreKeyFromReader(id, randutil.OrDefault(s.random))
 	_, err := s.db.Exec(insertPreKeyQuery, s.JID, key.KeyID, key.Priv[:], markUploaded)
 	return key, err
 }
@@ -256,8 +258,10 @@ func (s *SQLStore) UploadedPreKeyCount() (count int, err error) {
 }
 
 const (
-	getSenderKeyQuery = `SELECT sender_key FROM whatsmeow_sender_keys WHERE our_jid=? AND chat_id=? AND sender_id=?`
-	putSenderKeyQuery = `
+	getSenderKeyQuery     = `SELECT sender_key FROM whatsmeow_sender_keys WHERE our_jid=? AND chat_id=? AND sender_id=?`
+	getSenderKeysQuery    = `SELECT sender_id FROM whatsmeow_sender_keys WHERE our_jid=? AND chat_id=?`
+	deleteSenderKeysQuery = `DELETE FROM whatsmeow_sender_keys WHERE our_jid=? AND chat_id=?`
+	putSenderKeyQuery     = `
 		INSERT INTO whatsmeow_sender_keys (our_jid, chat_id, sender_id, sender_key) VALUES (?, ?, ?, ?)
         ON DUPLICATE KEY UPDATE sender_key=?
 	`
@@ -276,12 +280,42 @@ func (s *SQLStore) GetSenderKey(group, user string) (key []byte, err error) {
 	return
 }
 
+func (s *SQLStore) GetSenderKeys(group string) ([]string, error) {
+	rows, err := s.db.Query(getSenderKeysQuery, s.JID, group)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var senders []string
+	for rows.Next() {
+		var sender string
+		err = rows.Scan(&sender)
+		if err != nil {
+			return nil, err
+		}
+		senders = append(senders, sender)
+	}
+	return senders, rows.Err()
+}
+
+func (s *SQLStore) DeleteSenderKeys(group string) error {
+	_, err := s.db.Exec(deleteSenderKeysQuery, s.JID, group)
+	return err
+}
+
 const (
 	putAppStateSyncKeyQuery = `
 		INSERT INTO whatsmeow_app_state_sync_keys (jid, key_id, key_data, timestamp, fingerprint) VALUES (?, ?, ?, ?, ?)
 		ON DUPLICATE KEY UPDATE key_data=?, timestamp=?, fingerprint=?
 	`
-	getAppStateSyncKeyQuery = `SELECT key_data, timestamp, fingerprint FROM whatsmeow_app_state_sync_keys WHERE jid=? AND key_id=?`
+	getAppStateSyncKeyQuery    = `SELECT key_data, timestamp, fingerprint FROM whatsmeow_app_state_sync_keys WHERE jid=? AND key_id=?`
+	pruneAppStateSyncKeysQuery = `
+		DELETE FROM whatsmeow_app_state_sync_keys WHERE jid=? AND timestamp<? AND key_id NOT IN (
+			SELECT key_id FROM (
+				SELECT key_id FROM whatsmeow_app_state_sync_keys WHERE jid=? ORDER BY timestamp DESC LIMIT ?
+			) AS latest_keys
+		)
+	`
 )
 
 func (s *SQLStore) PutAppStateSyncKey(id []byte, key store.AppStateSyncKey) error {
@@ -298,6 +332,16 @@ func (s *SQLStore) GetAppStateSyncKey(id []byte) (*store.AppStateSyncKey, error)
 	return &key, err
 }
 
+// PruneAppStateSyncKeys deletes app state sync keys with a timestamp before the given value,
+// except for the keepLatest most recent keys. It returns the number of deleted keys.
+func (s *SQLStore) PruneAppStateSyncKeys(before int64, keepLatest int) (int64, error) {
+	res, err := s.db.Exec(pruneAppStateSyncKeysQuery, s.JID, before, s.JID, keepLatest)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
 const (
 	putAppStateVersionQuery = `
 		INSERT INTO whatsmeow_app_state_version (jid, name, version, hash) VALUES (?, ?, ?, ?)
@@ -313,7 +357,6 @@ const (
 
 func (s *SQLStore) PutAppStateVersion(name string, version uint64, hash [128]byte) error {
 	_, err := s.db.Exec(putAppStateVersionQuery, s.JID, name, version, hash[:], version, hash[:])
-	fmt.Errorf("failed to PutAppStateVersion: %w", err)
 	return err
 }
 
@@ -349,7 +392,6 @@ func (s *SQLStore) putAppStateMutationMACs(tx execable, name string, version uin
 		query := putAppStateMutationMACsQuery + "(?, ?, ?, ?, ?)"
 		_, err = tx.Exec(query, s.JID, name, version, mutation.IndexMAC, mutation.ValueMAC)
 		if err != nil {
-			fmt.Errorf("failed to putAppStateMutationMACs: %w", err)
 			return
 		}
 	}
@@ -0,0 +1,105 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/pfthink/whatsmeow/types"
+)
+
+// recordingDriver is a database driver that records executed statements instead of running them.
+type recordingDriver struct {
+	lock         sync.Mutex
+	queries      []string
+	args         [][]driver.Value
+	rowsAffected int64
+}
+
+type recordingConn struct {
+	drv *recordingDriver
+}
+
+type recordingStmt struct {
+	drv   *recordingDriver
+	query string
+}
+
+func (d *recordingDriver) Open(string) (driver.Conn, error) {
+	return &recordingConn{drv: d}, nil
+}
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return &recordingStmt{drv: c.drv, query: query}, nil
+}
+
+func (c *recordingConn) Close() error {
+	return nil
+}
+
+func (c *recordingConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions aren't supported")
+}
+
+func (s *recordingStmt) Close() error {
+	return nil
+}
+
+func (s *recordingStmt) NumInput() int {
+	return -1
+}
+
+func (s *recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.drv.lock.Lock()
+	defer s.drv.lock.Unlock()
+	s.drv.queries = append(s.drv.queries, s.query)
+	s.drv.args = append(s.drv.args, args)
+	return driver.RowsAffected(s.drv.rowsAffected), nil
+}
+
+func (s *recordingStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("queries aren't supported")
+}
+
+func (d *recordingDriver) Connect(context.Context) (driver.Conn, error) {
+	return d.Open("")
+}
+
+func (d *recordingDriver) Driver() driver.Driver {
+	return d
+}
+
+func newRecordingStore(t *testing.T, rowsAffected int64) (*SQLStore, *recordingDriver) {
+	drv := &recordingDriver{rowsAffected: rowsAffected}
+	db := sql.OpenDB(drv)
+	t.Cleanup(func() { _ = db.Close() })
+	return NewSQLStore(NewWithDB(db, "recording", nil), types.NewADJID("1111", 0, 1)), drv
+}
+
+func TestPruneAppStateSyncKeys(t *testing.T) {
+	s, drv := newRecordingStore(t, 3)
+	deleted, err := s.PruneAppStateSyncKeys(1656000000000, 5)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	} else if deleted != 3 {
+		t.Errorf("Expected 3 deleted keys, got %d", deleted)
+	}
+	if len(drv.queries) != 1 || drv.queries[0] != pruneAppStateSyncKeysQuery {
+		t.Fatalf("Expected the prune query to be executed once, got %q", drv.queries)
+	}
+	// The outer DELETE and the subquery of latest keys are both scoped to the store's JID
+	expectedArgs := []driver.Value{s.JID, int64(1656000000000), s.JID, int64(5)}
+	if !reflect.DeepEqual(drv.args[0], expectedArgs) {
+		t.Errorf("Expected arguments %v, got %v", expectedArgs, drv.args[0])
+	}
+}
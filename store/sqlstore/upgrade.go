@@ -16,7 +16,7 @@ type upgradeFunc func(*sql.Tx, *Container) error
 //
 // This may be of use if you want to manage the database fully manually, but in most cases you
 // should just call Container.Upgrade to let the library handle everything.
-var Upgrades = [...]upgradeFunc{upgradeV1, upgradeV2}
+var Upgrades = [...]upgradeFunc{upgradeV1, upgradeV2, upgradeV3}
 
 func (c *Container) getVersion() (int, error) {
 	_, err := c.db.Exec("CREATE TABLE IF NOT EXISTS whatsmeow_version (version INTEGER)")
@@ -238,3 +238,11 @@ func upgradeV2(tx *sql.Tx, container *Container) error {
 	return err*/
 	return nil
 }
+
+func upgradeV3(tx *sql.Tx, _ *Container) error {
+	_, err := tx.Exec(`ALTER TABLE whatsmeow_device
+    ADD COLUMN prev_signed_pre_key     varchar(32) null,
+    ADD COLUMN prev_signed_pre_key_id  int null,
+    ADD COLUMN prev_signed_pre_key_sig varchar(64) null`)
+	return err
+}
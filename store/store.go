@@ -44,6 +44,8 @@ type PreKeyStore interface {
 type SenderKeyStore interface {
 	PutSenderKey(group, user string, session []byte) error
 	GetSenderKey(group, user string) ([]byte, error)
+	GetSenderKeys(group string) ([]string, error)
+	DeleteSenderKeys(group string) error
 }
 
 type AppStateSyncKey struct {
@@ -57,6 +59,14 @@ type AppStateSyncKeyStore interface {
 	GetAppStateSyncKey(id []byte) (*AppStateSyncKey, error)
 }
 
+// AppStateSyncKeyPruner is an optional interface for AppStateSyncKeyStores that can delete old keys.
+// It is used when Client.AppStateSyncKeyRetention is set.
+type AppStateSyncKeyPruner interface {
+	// PruneAppStateSyncKeys deletes keys with a timestamp before the given one, except for the keepLatest
+	// most recent keys, and returns the number of keys deleted.
+	PruneAppStateSyncKeys(before int64, keepLatest int) (int64, error)
+}
+
 type AppStateMutationMAC struct {
 	IndexMAC []byte
 	ValueMAC []byte
@@ -109,6 +119,11 @@ type Device struct {
 	RegistrationID uint32
 	AdvSecretKey   []byte
 
+	// PreviousSignedPreKey is the signed prekey that was replaced by the last RotateSignedPreKey call.
+	// It's saved along with the current key, so that messages encrypted with it before the server started
+	// handing out the new key can still be decrypted after a restart.
+	PreviousSignedPreKey *keys.PreKey
+
 	ID           *types.JID
 	Account      *waProto.ADVSignedDeviceIdentity
 	Platform     string
@@ -141,6 +156,32 @@ func (device *Device) Save() error {
 	return device.Container.PutDevice(device)
 }
 
+// RotateSignedPreKey replaces the current signed prekey with the given one and saves the device.
+// The old key is kept in PreviousSignedPreKey. If saving fails, both keys are restored.
+func (device *Device) RotateSignedPreKey(newKey *keys.PreKey) error {
+	oldKey, oldPrevKey := device.SignedPreKey, device.PreviousSignedPreKey
+	device.PreviousSignedPreKey = oldKey
+	device.SignedPreKey = newKey
+	err := device.Save()
+	if err != nil {
+		device.SignedPreKey = oldKey
+		device.PreviousSignedPreKey = oldPrevKey
+	}
+	return err
+}
+
+// RevertSignedPreKeyRotation swaps the current and previous signed prekeys and saves the device.
+//
+// This is used when uploading a rotated key fails. The rejected key is kept as the previous key
+// rather than dropped, in case the server did accept it.
+func (device *Device) RevertSignedPreKeyRotation() error {
+	if device.PreviousSignedPreKey == nil {
+		return nil
+	}
+	device.SignedPreKey, device.PreviousSignedPreKey = device.PreviousSignedPreKey, device.SignedPreKey
+	return device.Save()
+}
+
 func (device *Device) Delete() error {
 	err := device.Container.DeleteDevice(device)
 	if err != nil {
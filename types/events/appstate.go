@@ -109,3 +109,26 @@ type AppState struct {
 type AppStateSyncComplete struct {
 	Name appstate.WAPatchName
 }
+
+// AccountSyncRequired is emitted when the server says that the account state is dirty and needs to be resynced,
+// which usually happens after relinking.
+//
+// If Client.AutoResyncOnAccountSync is enabled, all app state collections will have been fully resynced
+// before this is emitted. Otherwise, you can call Client.FetchAppState with fullSync=true for the collections you need.
+type AccountSyncRequired struct {
+	Timestamp time.Time
+	// Resynced is true if the client automatically resynced app state.
+	Resynced bool
+	// Errors contains the errors from collections that failed to resync, if any.
+	Errors []error
+}
+
+// AppStateSyncProgress is emitted for each app state collection fetched after pairing when Client.RequestAppStateOnPair is enabled.
+// The fetch starts when the phone shares the app state keys, which is usually soon after the first Connected event.
+type AppStateSyncProgress struct {
+	Name  appstate.WAPatchName // The collection that was just fetched.
+	Error error                // The error if fetching the collection failed.
+
+	Completed int // The number of collections fetched so far, including this one.
+	Total     int // The total number of collections that will be fetched.
+}
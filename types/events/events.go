@@ -11,11 +11,25 @@ import (
 	"fmt"
 	"time"
 
+	"google.golang.org/protobuf/reflect/protoreflect"
+
 	waBinary "github.com/pfthink/whatsmeow/binary"
 	waProto "github.com/pfthink/whatsmeow/binary/proto"
 	"github.com/pfthink/whatsmeow/types"
 )
 
+// EventMeta contains metadata about the stanza that an event was created from.
+//
+// It's passed to handlers registered with AddEventHandlerWithMeta alongside the event.
+// Events that aren't caused by any specific stanza (e.g. Disconnected) don't have metadata. Events created from
+// data fetched separately, like HistorySync and AppState, don't have metadata either.
+type EventMeta struct {
+	// The id attribute of the stanza. Some stanzas don't have IDs, in which case this is empty.
+	SourceID string
+	// The time when the stanza was read from the websocket, before it waited in the handler queue.
+	ReceivedAt time.Time
+}
+
 // QR is emitted after connecting when there's no session data in the device store.
 //
 // The QR codes are available in the Codes slice. You should render the strings as QR codes one by
@@ -194,6 +208,67 @@ type HistorySync struct {
 	Data *waProto.HistorySync
 }
 
+// HistorySyncProgress is emitted after each history sync blob has been dispatched as a HistorySync event.
+type HistorySyncProgress struct {
+	Type waProto.HistorySync_HistorySyncHistorySyncType
+	// Received is the number of chunks of this sync type that have been received so far.
+	Received int
+	// Total is the total number of chunks in this sync. The phone doesn't tell how many chunks are coming,
+	// so this is zero until the final chunk has been received, at which point it's equal to Received.
+	Total int
+	// Progress is the overall progress of this sync type as a percentage (0-100), as reported by the phone.
+	// Syncs that are sent as a single chunk (e.g. push names) don't report progress, so it's zero for them.
+	Progress uint32
+}
+
+// HistorySyncComplete is emitted after the final chunk of a history sync has been dispatched. A chunk is
+// final if the phone reports 100% progress, or if the sync doesn't report progress at all, as such syncs
+// only consist of a single chunk.
+type HistorySyncComplete struct {
+	Type waProto.HistorySync_HistorySyncHistorySyncType
+	// Chunks is the number of chunks that were received for this sync.
+	Chunks int
+}
+
+// ButtonResponseType is the type of message that a ButtonResponse came from.
+type ButtonResponseType string
+
+const (
+	ButtonResponseTypeButtons    ButtonResponseType = "buttons"     // A ButtonsResponseMessage
+	ButtonResponseTypeTemplate   ButtonResponseType = "template"    // A TemplateButtonReplyMessage
+	ButtonResponseTypeNativeFlow ButtonResponseType = "native_flow" // An InteractiveResponseMessage with a native flow response
+)
+
+// ButtonResponse is emitted when someone taps a button in a buttons, template or native flow message.
+// It's emitted right after the Message event that contains the response.
+type ButtonResponse struct {
+	Info types.MessageInfo
+	Type ButtonResponseType
+	// The ID of the message that contained the button, if the response included it.
+	OriginalMessageID types.MessageID
+
+	SelectedID          string
+	SelectedDisplayText string
+	SelectedIndex       int // Only set for template button replies.
+
+	// The name and parameters of the native flow response. Only set for native flow responses.
+	NativeFlowName       string
+	NativeFlowParamsJSON string
+}
+
+// MessageRevoke is emitted when a message is deleted for everyone. It's emitted right after the Message event
+// that contains the revoke protocol message.
+type MessageRevoke struct {
+	Chat      types.JID       // The chat where the revoked message was sent.
+	Sender    types.JID       // The user who sent the revoked message.
+	RevokedBy types.JID       // The user who revoked the message.
+	MessageID types.MessageID // The ID of the revoked message.
+	Timestamp time.Time       // The time when the message was revoked.
+
+	// IsAdminRevoke is true if a group admin deleted someone else's message.
+	IsAdminRevoke bool
+}
+
 // UndecryptableMessage is emitted when receiving a new message that failed to decrypt.
 //
 // The library will automatically ask the sender to retry. If the sender resends the message,
@@ -239,9 +314,57 @@ func (evt *Message) UnwrapRaw() *Message {
 		evt.Message = evt.Message.GetViewOnceMessage().GetMessage()
 		evt.IsViewOnce = true
 	}
+	if adReply := getContextInfo(evt.Message).GetExternalAdReply(); adReply != nil {
+		evt.Info.AdReply = &types.AdReplyInfo{
+			SourceID:     adReply.GetSourceId(),
+			SourceType:   adReply.GetSourceType(),
+			SourceURL:    adReply.GetSourceUrl(),
+			Title:        adReply.GetTitle(),
+			Body:         adReply.GetBody(),
+			ThumbnailURL: adReply.GetThumbnailUrl(),
+		}
+	}
 	return evt
 }
 
+// FrequentlyForwardedThreshold is the forwarding score at which messages are shown as "forwarded many times".
+const FrequentlyForwardedThreshold = 4
+
+// ForwardingScore returns the number of times the message has been forwarded, or zero if it isn't a forwarded message.
+func (evt *Message) ForwardingScore() uint32 {
+	contextInfo := getContextInfo(evt.Message)
+	if !contextInfo.GetIsForwarded() {
+		return 0
+	}
+	return contextInfo.GetForwardingScore()
+}
+
+// IsFrequentlyForwarded returns true if the message has been forwarded many times (i.e. the forwarding score
+// is at least FrequentlyForwardedThreshold).
+func (evt *Message) IsFrequentlyForwarded() bool {
+	return evt.ForwardingScore() >= FrequentlyForwardedThreshold
+}
+
+// getContextInfo finds the ContextInfo of whichever message type is set in the given message.
+func getContextInfo(msg *waProto.Message) (contextInfo *waProto.ContextInfo) {
+	if msg == nil {
+		return nil
+	}
+	msg.ProtoReflect().Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		if field.Kind() != protoreflect.MessageKind || field.IsList() || field.IsMap() {
+			return true
+		}
+		subMsg := value.Message()
+		ciField := subMsg.Descriptor().Fields().ByName("contextInfo")
+		if ciField == nil || !subMsg.Has(ciField) {
+			return true
+		}
+		contextInfo, _ = subMsg.Get(ciField).Message().Interface().(*waProto.ContextInfo)
+		return contextInfo == nil
+	})
+	return
+}
+
 // ReceiptType represents the type of a Receipt event.
 type ReceiptType string
 
@@ -254,6 +377,8 @@ const (
 	ReceiptTypeRead ReceiptType = "read"
 	// ReceiptTypeReadSelf means the current user read a message from a different device, and has read receipts disabled in privacy settings.
 	ReceiptTypeReadSelf ReceiptType = "read-self"
+	// ReceiptTypePlayed means the user opened a voice message or other playable media.
+	ReceiptTypePlayed ReceiptType = "played"
 )
 
 // GoString returns the name of the Go constant for the ReceiptType value.
@@ -265,6 +390,8 @@ func (rt ReceiptType) GoString() string {
 		return "events.ReceiptTypeReadSelf"
 	case ReceiptTypeDelivered:
 		return "events.ReceiptTypeDelivered"
+	case ReceiptTypePlayed:
+		return "events.ReceiptTypePlayed"
 	default:
 		return fmt.Sprintf("events.ReceiptType(%#v)", string(rt))
 	}
@@ -322,6 +449,9 @@ type GroupInfo struct {
 	Announce  *types.GroupAnnounce  // Group announce status change (can only admins send messages?)
 	Ephemeral *types.GroupEphemeral // Disappearing messages change
 
+	MemberAddMode          *types.GroupMemberAddMode          // Change of who can add new members
+	MembershipApprovalMode *types.GroupMembershipApprovalMode // Change of whether joining requires admin approval
+
 	NewInviteLink *string // Group invite link change
 
 	PrevParticipantVersionID string
@@ -338,6 +468,24 @@ type GroupInfo struct {
 	UnknownChanges []*waBinary.Node
 }
 
+// GroupJoinRequest is emitted when someone requests to join a group that requires admin approval.
+// It's only received for groups where the current user is an admin.
+//
+// The request can be approved or rejected with Client.UpdateGroupJoinRequests.
+type GroupJoinRequest struct {
+	JID           types.JID // The group ID
+	Requester     types.JID // The user who requested to join
+	RequestMethod string    // How the user found the group, e.g. "invite_link"
+	Timestamp     time.Time // The time when the request was made
+}
+
+// OwnProfile is emitted by Client.RefreshOwnProfile after the current user's profile has been fetched from the server.
+type OwnProfile struct {
+	PushName  string // The push name, also stored in the PushName field of the device store.
+	Status    string // The about text.
+	PictureID string // The ID of the current profile picture, or empty if there's no picture.
+}
+
 // Picture is emitted when a user's profile picture or group's photo is changed.
 //
 // You can use Client.GetProfilePictureInfo to get the actual image URL after this event.
@@ -349,6 +497,48 @@ type Picture struct {
 	PictureID string    // The new picture ID if it was not removed.
 }
 
+// SenderKeyDistribution is emitted when a sender key distribution message from a group member is processed.
+//
+// This is only emitted if Client.EmitSenderKeyEvents is true.
+type SenderKeyDistribution struct {
+	Chat      types.JID // The group the sender key is for.
+	Sender    types.JID // The device that sent the sender key.
+	KeyID     uint32    // The ID of the sender key.
+	Iteration uint32    // The chain iteration the sender key starts from.
+}
+
+// PhoneNumberChange is emitted when a contact changes their phone number.
+//
+// Messages from the contact will come from NewJID after this. If Client.MigrateContactOnNumberChange
+// is enabled, the locally stored contact info of OldJID will have been copied to NewJID before this is emitted.
+type PhoneNumberChange struct {
+	OldJID    types.JID
+	NewJID    types.JID
+	Timestamp time.Time
+}
+
+// SignedPreKeyRotated is emitted after Client.RotateSignedPreKey has stored and uploaded a new signed prekey.
+type SignedPreKeyRotated struct {
+	KeyID         uint32
+	PreviousKeyID uint32
+}
+
+// CompanionAdded is emitted when a new companion device is linked to our own account.
+//
+// Devices linked while the client was offline are found by comparing the device list in account sync
+// notifications to the cached own device list, so they're only emitted if the list was cached beforehand.
+type CompanionAdded struct {
+	JID       types.JID // The JID of the new device.
+	Timestamp time.Time
+}
+
+// CompanionRemoved is emitted when a companion device is unlinked from our own account.
+// The same caveat about offline changes as for CompanionAdded applies.
+type CompanionRemoved struct {
+	JID       types.JID // The JID of the removed device.
+	Timestamp time.Time
+}
+
 // IdentityChange is emitted when another user changes their primary device.
 type IdentityChange struct {
 	JID       types.JID
@@ -384,6 +574,25 @@ type OfflineSyncCompleted struct {
 	Count int
 }
 
+// ConnectTiming is emitted after the offline sync of a connection has completed, and contains the time spent
+// in each phase of establishing the connection. All durations are in milliseconds.
+//
+// The end of the offline sync is signaled by the server with an offline ib node (the same one that triggers
+// OfflineSyncCompleted). If the server doesn't send one for a connection, this event isn't emitted for it.
+type ConnectTiming struct {
+	DialMs        int64 // Time taken to open the websocket connection.
+	HandshakeMs   int64 // Time taken for the noise handshake, including sending the login payload.
+	LoginMs       int64 // Time between finishing the handshake and receiving the login success.
+	OfflineSyncMs int64 // Time between the login success and the server finishing sending missed events.
+}
+
+// DirtyCleaned is emitted after the server has sent a dirty notification (e.g. for account_sync or groups)
+// and the client has successfully told the server that the state was cleaned.
+type DirtyCleaned struct {
+	Type      string
+	Timestamp time.Time
+}
+
 type MediaRetryError struct {
 	Code int
 }
@@ -20,9 +20,16 @@ type GroupInfo struct {
 	GroupLocked
 	GroupAnnounce
 	GroupEphemeral
+	GroupMembershipApprovalMode
+
+	GroupParent
+	GroupLinkedParent
+	GroupIsDefaultSub
 
 	GroupCreated time.Time
 
+	MemberAddMode GroupMemberAddMode
+
 	ParticipantVersionID string
 	Participants         []GroupParticipant
 }
@@ -53,6 +60,21 @@ type GroupAnnounce struct {
 	AnnounceVersionID string
 }
 
+// GroupMemberAddMode specifies who can add new members to a group.
+type GroupMemberAddMode string
+
+const (
+	// GroupMemberAddModeAdmin means only admins can add new members.
+	GroupMemberAddModeAdmin GroupMemberAddMode = "admin_add"
+	// GroupMemberAddModeAllMember means all members can add new members.
+	GroupMemberAddModeAllMember GroupMemberAddMode = "all_member_add"
+)
+
+// GroupMembershipApprovalMode specifies whether new members need to be approved by an admin before joining the group.
+type GroupMembershipApprovalMode struct {
+	IsJoinApprovalRequired bool
+}
+
 // GroupParticipant contains info about a participant of a WhatsApp group chat.
 type GroupParticipant struct {
 	JID          JID
@@ -60,8 +82,36 @@ type GroupParticipant struct {
 	IsSuperAdmin bool
 }
 
+// GroupJoinRequest contains info about a pending request to join a group that requires admin approval.
+type GroupJoinRequest struct {
+	JID         JID
+	RequestedAt time.Time
+}
+
 // GroupEphemeral contains the group's disappearing messages settings.
 type GroupEphemeral struct {
 	IsEphemeral       bool
 	DisappearingTimer uint32
 }
+
+// GroupParent contains the community info of a group, i.e. whether it's a community (parent group).
+type GroupParent struct {
+	IsParent bool
+}
+
+// GroupLinkedParent contains the JID of the community that the group is linked to.
+type GroupLinkedParent struct {
+	LinkedParentJID JID
+}
+
+// GroupIsDefaultSub specifies whether the group is the default (announcement) group of a community.
+type GroupIsDefaultSub struct {
+	IsDefaultSubGroup bool
+}
+
+// GroupLinkTarget contains basic info about a group linked to a community.
+type GroupLinkTarget struct {
+	JID JID
+	GroupName
+	GroupIsDefaultSub
+}
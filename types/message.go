@@ -47,7 +47,24 @@ type MessageInfo struct {
 	Multicast bool      `json:"multicast"`
 	MediaType string    `json:"mediaType"`
 
+	// The type of the encrypted payload the message was decrypted from: "pkmsg" (prekey signal message),
+	// "msg" (normal signal message) or "skmsg" (sender key message, only used in groups).
+	EncType string `json:"encType"`
+	// The version of the encryption protocol used for the encrypted payload.
+	DecryptVersion int `json:"decryptVersion"`
+
 	DeviceSentMeta *DeviceSentMeta `json:"deviceSentMeta"` // Metadata for direct messages sent from another one of the user's own devices.
+	AdReply        *AdReplyInfo    `json:"adReply"`        // Info about the ad the message was sent from (click-to-WhatsApp ads).
+}
+
+// AdReplyInfo contains info about the ad that the user clicked to send a message, e.g. on Facebook or Instagram.
+type AdReplyInfo struct {
+	SourceID     string `json:"sourceID"`   // The ID of the ad.
+	SourceType   string `json:"sourceType"` // The type of the ad source, e.g. "ad".
+	SourceURL    string `json:"sourceURL"`
+	Title        string `json:"title"`
+	Body         string `json:"body"`
+	ThumbnailURL string `json:"thumbnailURL"`
 }
 
 // SourceString returns a log-friendly representation of who sent the message and where.
@@ -16,6 +16,9 @@ import (
 type VerifiedName struct {
 	Certificate *waProto.VerifiedNameCertificate
 	Details     *waProto.VerifiedNameDetails
+
+	// The verification level of the business (e.g. "unknown", "low" or "high"). This is only set by Client.GetVerifiedName.
+	Level string
 }
 
 // UserInfo contains info about a WhatsApp user.
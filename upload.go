@@ -33,6 +33,28 @@ type UploadResponse struct {
 	FileLength    uint64 `json:"-"`
 }
 
+// UploadResponseFromMessage extracts the upload info from an already uploaded attachment,
+// which allows forwarding media without downloading and uploading it again.
+//
+// The fields of the returned value can be copied into a new protobuf message the same way as with the response of
+// Upload. The media key is derived separately for each media type, so the new message must be the same type
+// (e.g. the info from an ImageMessage can only be reused in another ImageMessage).
+func UploadResponseFromMessage(msg DownloadableMessage) UploadResponse {
+	resp := UploadResponse{
+		DirectPath:    msg.GetDirectPath(),
+		MediaKey:      msg.GetMediaKey(),
+		FileEncSHA256: msg.GetFileEncSha256(),
+		FileSHA256:    msg.GetFileSha256(),
+	}
+	if urlable, ok := msg.(downloadableMessageWithURL); ok {
+		resp.URL = urlable.GetUrl()
+	}
+	if size := getSize(msg); size >= 0 {
+		resp.FileLength = uint64(size)
+	}
+	return resp
+}
+
 // Upload uploads the given attachment to WhatsApp servers.
 //
 // You should copy the fields in the response to the corresponding fields in a protobuf message.
@@ -59,7 +81,16 @@ type UploadResponse struct {
 //   // handle error again
 //
 // The same applies to the other message types like DocumentMessage, just replace the struct type and Message field name.
+//
+// SendMessage never uploads anything by itself, so media that has already been uploaded (e.g. when forwarding)
+// can be sent with the existing attributes: see UploadResponseFromMessage.
+//
+// Canceling the context aborts the upload, in which case the returned error will match context.Canceled
+// (or context.DeadlineExceeded) when checked with errors.Is.
 func (cli *Client) Upload(ctx context.Context, plaintext []byte, appInfo MediaType) (resp UploadResponse, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
 	resp.FileLength = uint64(len(plaintext))
 	resp.MediaKey = make([]byte, 32)
 	_, err = rand.Read(resp.MediaKey)
@@ -99,7 +130,11 @@ func (cli *Client) Upload(ctx context.Context, plaintext []byte, appInfo MediaTy
 		"auth":  []string{mediaConn.Auth},
 		"token": []string{token},
 	}
-	mmsType := mediaTypeToMMSType[appInfo]
+	mmsType, ok := GetMMSType(appInfo)
+	if !ok {
+		err = fmt.Errorf("%w '%s'", ErrUnknownMediaType, string(appInfo))
+		return
+	}
 	uploadURL := url.URL{
 		Scheme:   "https",
 		Host:     mediaConn.Hosts[0].Hostname,
@@ -7,12 +7,17 @@
 package whatsmeow
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"strings"
+	"time"
 
 	"google.golang.org/protobuf/proto"
 
+	"github.com/pfthink/whatsmeow/appstate"
 	waBinary "github.com/pfthink/whatsmeow/binary"
 	waProto "github.com/pfthink/whatsmeow/binary/proto"
 	"github.com/pfthink/whatsmeow/types"
@@ -70,21 +75,43 @@ func (cli *Client) ResolveBusinessMessageLink(code string) (*types.BusinessMessa
 	return &target, ag.Error()
 }
 
+// IsOnWhatsAppResponseWithRaw contains the result of IsOnWhatsAppWithRaw for one phone number, along with
+// the raw user node from the usync response for reading attributes that aren't parsed yet.
+type IsOnWhatsAppResponseWithRaw struct {
+	types.IsOnWhatsAppResponse
+	Raw *waBinary.Node
+}
+
 // IsOnWhatsApp checks if the given phone numbers are registered on WhatsApp.
 // The phone numbers should be in international format, including the `+` prefix.
 func (cli *Client) IsOnWhatsApp(phones []string) ([]types.IsOnWhatsAppResponse, error) {
+	responses, err := cli.IsOnWhatsAppWithRaw(phones)
+	if err != nil {
+		return nil, err
+	}
+	output := make([]types.IsOnWhatsAppResponse, len(responses))
+	for i, resp := range responses {
+		output[i] = resp.IsOnWhatsAppResponse
+	}
+	return output, nil
+}
+
+// IsOnWhatsAppWithRaw is like IsOnWhatsApp, but it also returns the raw response node of each user.
+// Any extra query nodes are added to the usync query, so that data which isn't parsed yet can be
+// requested in the same query and read from the raw nodes.
+func (cli *Client) IsOnWhatsAppWithRaw(phones []string, extraQuery ...waBinary.Node) ([]IsOnWhatsAppResponseWithRaw, error) {
 	jids := make([]types.JID, len(phones))
 	for i := range jids {
 		jids[i] = types.NewJID(phones[i], types.LegacyUserServer)
 	}
-	list, err := cli.usync(jids, "query", "interactive", []waBinary.Node{
+	list, err := cli.usync(jids, "query", "interactive", append([]waBinary.Node{
 		{Tag: "business", Content: []waBinary.Node{{Tag: "verified_name"}}},
 		{Tag: "contact"},
-	})
+	}, extraQuery...))
 	if err != nil {
 		return nil, err
 	}
-	output := make([]types.IsOnWhatsAppResponse, 0, len(jids))
+	output := make([]IsOnWhatsAppResponseWithRaw, 0, len(jids))
 	querySuffix := "@" + types.LegacyUserServer
 	for _, child := range list.GetChildren() {
 		jid, jidOK := child.Attrs["jid"].(types.JID)
@@ -101,23 +128,46 @@ func (cli *Client) IsOnWhatsApp(phones []string) ([]types.IsOnWhatsAppResponse,
 		info.IsIn = contactNode.AttrGetter().String("type") == "in"
 		contactQuery, _ := contactNode.Content.([]byte)
 		info.Query = strings.TrimSuffix(string(contactQuery), querySuffix)
-		output = append(output, info)
+		raw := child
+		output = append(output, IsOnWhatsAppResponseWithRaw{IsOnWhatsAppResponse: info, Raw: &raw})
 	}
 	return output, nil
 }
 
+// UserInfoWithRaw contains the result of GetUserInfoWithRaw for one user, along with the raw user node
+// from the usync response for reading attributes that aren't parsed yet.
+type UserInfoWithRaw struct {
+	types.UserInfo
+	Raw *waBinary.Node
+}
+
 // GetUserInfo gets basic user info (avatar, status, verified business name, device list).
 func (cli *Client) GetUserInfo(jids []types.JID) (map[types.JID]types.UserInfo, error) {
-	list, err := cli.usync(jids, "full", "background", []waBinary.Node{
+	infos, err := cli.GetUserInfoWithRaw(jids)
+	if err != nil {
+		return nil, err
+	}
+	respData := make(map[types.JID]types.UserInfo, len(infos))
+	for jid, info := range infos {
+		respData[jid] = info.UserInfo
+	}
+	return respData, nil
+}
+
+// GetUserInfoWithRaw is like GetUserInfo, but it also returns the raw response node of each user.
+// Any extra query nodes are added to the usync query, so that data which isn't parsed yet can be
+// requested in the same query and read from the raw nodes.
+func (cli *Client) GetUserInfoWithRaw(jids []types.JID, extraQuery ...waBinary.Node) (map[types.JID]UserInfoWithRaw, error) {
+	list, err := cli.usync(jids, "full", "background", append([]waBinary.Node{
 		{Tag: "business", Content: []waBinary.Node{{Tag: "verified_name"}}},
 		{Tag: "status"},
 		{Tag: "picture"},
 		{Tag: "devices", Attrs: waBinary.Attrs{"version": "2"}},
-	})
+	}, extraQuery...))
 	if err != nil {
 		return nil, err
 	}
-	respData := make(map[types.JID]types.UserInfo, len(jids))
+	respData := make(map[types.JID]UserInfoWithRaw, len(jids))
 	for _, child := range list.GetChildren() {
 		jid, jidOK := child.Attrs["jid"].(types.JID)
 		if child.Tag != "user" || !jidOK {
@@ -133,13 +183,64 @@ func (cli *Client) GetUserInfo(jids []types.JID) (map[types.JID]types.UserInfo,
 		info.PictureID, _ = child.GetChildByTag("picture").Attrs["id"].(string)
 		info.Devices = parseDeviceList(jid.User, child.GetChildByTag("devices"))
 		if verifiedName != nil {
+			info.VerifiedName = verifiedName
 			cli.updateBusinessName(jid, verifiedName.Details.GetVerifiedName())
 		}
-		respData[jid] = info
+		raw := child
+		respData[jid] = UserInfoWithRaw{UserInfo: info, Raw: &raw}
 	}
 	return respData, nil
 }
 
+// GetVerifiedName gets the verified business name certificate of the given user, including the verification level.
+//
+// If the user is not a business or doesn't have a verified name, this returns nil with no error.
+func (cli *Client) GetVerifiedName(jid types.JID) (*types.VerifiedName, error) {
+	resp, err := cli.sendIQ(infoQuery{
+		Namespace: "w:biz",
+		Type:      iqGet,
+		To:        types.ServerJID,
+		Content: []waBinary.Node{{
+			Tag:   "verified_name",
+			Attrs: waBinary.Attrs{"jid": jid.ToNonAD()},
+		}},
+	})
+	if errors.Is(err, ErrIQNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	verifiedNameNode, ok := resp.GetOptionalChildByTag("verified_name")
+	if !ok {
+		return nil, nil
+	}
+	verifiedName, err := parseVerifiedNameContent(verifiedNameNode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse verified name certificate: %w", err)
+	} else if verifiedName != nil {
+		verifiedName.Level = verifiedNameNode.AttrGetter().OptionalString("verified_level")
+	}
+	return verifiedName, nil
+}
+
+type deviceCache struct {
+	devices   []types.JID
+	fetchedAt time.Time
+}
+
+// RefreshDeviceList removes the cached device list of the given user and fetches it again from the server.
+//
+// This can be used before sending a message if the user might have linked a new device that the cache doesn't
+// know about yet. The next message to the user will be encrypted for the refreshed device list.
+func (cli *Client) RefreshDeviceList(jid types.JID) error {
+	jid = jid.ToNonAD()
+	cli.userDevicesCacheLock.Lock()
+	delete(cli.userDevicesCache, jid)
+	cli.userDevicesCacheLock.Unlock()
+	_, err := cli.GetUserDevices([]types.JID{jid})
+	return err
+}
+
 // GetUserDevices gets the list of devices that the given user has. The input should be a list of
 // regular JIDs, and the output will be a list of AD JIDs. The local device will not be included in
 // the output even if the user's JID is included in the input. All other devices will be included.
@@ -150,8 +251,8 @@ func (cli *Client) GetUserDevices(jids []types.JID) ([]types.JID, error) {
 	var devices, jidsToSync []types.JID
 	for _, jid := range jids {
 		cached, ok := cli.userDevicesCache[jid]
-		if ok && len(cached) > 0 {
-			devices = append(devices, cached...)
+		if ok && len(cached.devices) > 0 && (cli.DeviceListCacheTTL <= 0 || time.Since(cached.fetchedAt) < cli.DeviceListCacheTTL) {
+			devices = append(devices, cached.devices...)
 		} else {
 			jidsToSync = append(jidsToSync, jid)
 		}
@@ -173,16 +274,87 @@ func (cli *Client) GetUserDevices(jids []types.JID) ([]types.JID, error) {
 			continue
 		}
 		userDevices := parseDeviceList(jid.User, user.GetChildByTag("devices"))
-		cli.userDevicesCache[jid] = userDevices
+		cli.userDevicesCache[jid] = deviceCache{devices: userDevices, fetchedAt: time.Now()}
 		devices = append(devices, userDevices...)
 	}
 
 	return devices, nil
 }
 
+// RefreshOwnProfile fetches the current push name, about text and profile picture of the logged in user from the
+// server, so changes made on the phone are picked up without relinking. An events.OwnProfile is dispatched with
+// the result.
+//
+// The push name is synced through app state, so this will also dispatch an events.PushNameSetting if it changed,
+// and the new name is saved in the device store. The about text and picture ID are not persisted.
+func (cli *Client) RefreshOwnProfile() error {
+	if cli.Store.ID == nil {
+		return ErrNotLoggedIn
+	}
+	ownID := cli.Store.ID.ToNonAD()
+	err := cli.FetchAppState(appstate.WAPatchCriticalBlock, false, false)
+	if err != nil {
+		// Getting the rest of the profile is still useful, so don't abort here
+		cli.Log.Warnf("Failed to sync app state to get own push name: %v", err)
+	}
+	infos, err := cli.GetUserInfo([]types.JID{ownID})
+	if err != nil {
+		return fmt.Errorf("failed to get own user info: %w", err)
+	}
+	evt := &events.OwnProfile{
+		PushName: cli.Store.PushName,
+		Status:   infos[ownID].Status,
+	}
+	picture, err := cli.GetProfilePictureInfo(ownID, false)
+	if err != nil {
+		return fmt.Errorf("failed to get own profile picture: %w", err)
+	} else if picture != nil {
+		evt.PictureID = picture.ID
+	}
+	cli.dispatchEvent(evt)
+	return nil
+}
+
 // GetProfilePictureInfo gets the URL where you can download a WhatsApp user's profile picture or group's photo.
 // If the user or group doesn't have a profile picture, this returns nil with no error.
 func (cli *Client) GetProfilePictureInfo(jid types.JID, preview bool) (*types.ProfilePictureInfo, error) {
+	return cli.getProfilePictureInfo(jid, preview, "")
+}
+
+// GetProfilePicture downloads the full resolution profile picture of a WhatsApp user or group.
+//
+// If existingID is set and the picture hasn't changed (i.e. the current picture ID is the same),
+// ErrProfilePictureNotModified is returned without downloading anything. If the user or group doesn't have
+// a profile picture, this returns nil with no error.
+func (cli *Client) GetProfilePicture(ctx context.Context, jid types.JID, existingID string) ([]byte, *types.ProfilePictureInfo, error) {
+	info, err := cli.getProfilePictureInfo(jid, false, existingID)
+	if err != nil {
+		return nil, nil, err
+	} else if info == nil {
+		return nil, nil, nil
+	} else if len(existingID) > 0 && info.ID == existingID {
+		return nil, info, ErrProfilePictureNotModified
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, info.URL, nil)
+	if err != nil {
+		return nil, info, fmt.Errorf("failed to prepare request: %w", err)
+	}
+	resp, err := cli.http.Do(req)
+	if err != nil {
+		return nil, info, fmt.Errorf("failed to download profile picture: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, info, fmt.Errorf("profile picture download failed with status code %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, info, fmt.Errorf("failed to read profile picture: %w", err)
+	}
+	return data, info, nil
+}
+
+func (cli *Client) getProfilePictureInfo(jid types.JID, preview bool, existingID string) (*types.ProfilePictureInfo, error) {
 	attrs := waBinary.Attrs{
 		"query": "url",
 	}
@@ -191,6 +363,9 @@ func (cli *Client) GetProfilePictureInfo(jid types.JID, preview bool) (*types.Pr
 	} else {
 		attrs["type"] = "image"
 	}
+	if len(existingID) > 0 {
+		attrs["id"] = existingID
+	}
 	resp, err := cli.sendIQ(infoQuery{
 		Namespace: "w:profile:picture",
 		Type:      "get",
@@ -209,6 +384,10 @@ func (cli *Client) GetProfilePictureInfo(jid types.JID, preview bool) (*types.Pr
 	}
 	picture, ok := resp.GetOptionalChildByTag("picture")
 	if !ok {
+		if len(existingID) > 0 {
+			// The server leaves out the picture if the ID hasn't changed
+			return &types.ProfilePictureInfo{ID: existingID}, nil
+		}
 		return nil, &ElementMissingError{Tag: "picture", In: "response to profile picture query"}
 	}
 	var info types.ProfilePictureInfo
@@ -243,7 +422,7 @@ func (cli *Client) handleHistoricalPushNames(names []*waProto.Pushname) {
 	}
 }
 
-func (cli *Client) updatePushName(user types.JID, messageInfo *types.MessageInfo, name string) {
+func (cli *Client) updatePushName(user types.JID, messageInfo *types.MessageInfo, name string, meta *events.EventMeta) {
 	if cli.Store.Contacts == nil {
 		return
 	}
@@ -253,12 +432,12 @@ func (cli *Client) updatePushName(user types.JID, messageInfo *types.MessageInfo
 		cli.Log.Errorf("Failed to save push name of %s in device store: %v", user, err)
 	} else if changed {
 		cli.Log.Debugf("Push name of %s changed from %s to %s, dispatching event", user, previousName, name)
-		cli.dispatchEvent(&events.PushName{
+		cli.dispatchEventWithMeta(&events.PushName{
 			JID:         user,
 			Message:     messageInfo,
 			OldPushName: previousName,
 			NewPushName: name,
-		})
+		}, meta)
 	}
 }
 
@@ -272,6 +451,40 @@ func (cli *Client) updateBusinessName(user types.JID, name string) {
 	}
 }
 
+// ResolveDisplayName finds the most meaningful name to show for the sender of the given message.
+//
+// The name is chosen in this order:
+//  1. the push name in the message (the notify attribute), or the last stored push name of the sender
+//  2. the verified business name of the sender, if it has been fetched before (e.g. with GetUserInfo)
+//  3. the phone number of the sender, formatted with a + prefix
+//
+// Address book names are intentionally not used, call Store.Contacts.GetContact directly if you want those.
+// Only locally stored data is used, so this never makes any requests to the server.
+func (cli *Client) ResolveDisplayName(info types.MessageInfo) string {
+	sender := info.Sender.ToNonAD()
+	if info.IsFromMe && len(cli.Store.PushName) > 0 {
+		return cli.Store.PushName
+	}
+	var contact types.ContactInfo
+	if cli.Store.Contacts != nil {
+		var err error
+		contact, err = cli.Store.Contacts.GetContact(sender)
+		if err != nil {
+			cli.Log.Warnf("Failed to get contact info of %s to resolve display name: %v", sender, err)
+		}
+	}
+	switch {
+	case len(info.PushName) > 0 && info.PushName != "-":
+		return info.PushName
+	case len(contact.PushName) > 0:
+		return contact.PushName
+	case len(contact.BusinessName) > 0:
+		return contact.BusinessName
+	default:
+		return "+" + sender.User
+	}
+}
+
 func parseVerifiedName(businessNode waBinary.Node) (*types.VerifiedName, error) {
 	if businessNode.Tag != "business" {
 		return nil, nil
@@ -280,6 +493,10 @@ func parseVerifiedName(businessNode waBinary.Node) (*types.VerifiedName, error)
 	if !ok {
 		return nil, nil
 	}
+	return parseVerifiedNameContent(verifiedNameNode)
+}
+
+func parseVerifiedNameContent(verifiedNameNode waBinary.Node) (*types.VerifiedName, error) {
 	rawCert, ok := verifiedNameNode.Content.([]byte)
 	if !ok {
 		return nil, nil
@@ -318,6 +535,39 @@ func parseDeviceList(user string, deviceNode waBinary.Node) []types.JID {
 	return devices
 }
 
+// USync sends a raw usync query and returns the raw response node of each user.
+//
+// This can be used to get attributes that the other methods (like GetUserInfo and IsOnWhatsApp) don't parse yet.
+// To get the parsed data of those methods in the same query, use GetUserInfoWithRaw or IsOnWhatsAppWithRaw instead.
+// The mode is usually "query" or "full", and the context "interactive", "background" or "message". For example,
+// to get the same data as GetUserInfo:
+//   nodes, err := cli.USync(jids, "full", "background", []waBinary.Node{
+//       {Tag: "business", Content: []waBinary.Node{{Tag: "verified_name"}}},
+//       {Tag: "status"},
+//       {Tag: "picture"},
+//       {Tag: "devices", Attrs: waBinary.Attrs{"version": "2"}},
+//   })
+//
+// The returned map is keyed by the JID the server returned for each user. JIDs on types.LegacyUserServer
+// are phone number lookups, so their results are keyed by the user's JID on types.DefaultUserServer rather
+// than by the JID that was queried.
+func (cli *Client) USync(jids []types.JID, mode, context string, query []waBinary.Node) (map[types.JID]*waBinary.Node, error) {
+	list, err := cli.usync(jids, mode, context, query)
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[types.JID]*waBinary.Node, len(jids))
+	for _, child := range list.GetChildren() {
+		jid, jidOK := child.Attrs["jid"].(types.JID)
+		if child.Tag != "user" || !jidOK {
+			continue
+		}
+		user := child
+		users[jid] = &user
+	}
+	return users, nil
+}
+
 func (cli *Client) usync(jids []types.JID, mode, context string, query []waBinary.Node) (*waBinary.Node, error) {
 	userList := make([]waBinary.Node, len(jids))
 	for i, jid := range jids {
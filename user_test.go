@@ -0,0 +1,209 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	waBinary "github.com/pfthink/whatsmeow/binary"
+	"github.com/pfthink/whatsmeow/store"
+	"github.com/pfthink/whatsmeow/types"
+)
+
+type singleContactStore struct {
+	store.ContactStore
+	user    types.JID
+	contact types.ContactInfo
+}
+
+func (scs *singleContactStore) GetContact(user types.JID) (types.ContactInfo, error) {
+	if user != scs.user {
+		return types.ContactInfo{}, nil
+	}
+	return scs.contact, nil
+}
+
+func TestResolveDisplayName(t *testing.T) {
+	sender := types.NewJID("1111", types.DefaultUserServer)
+	senderDevice := types.NewADJID("1111", 0, 2)
+	tests := []struct {
+		name     string
+		info     types.MessageInfo
+		contact  types.ContactInfo
+		expected string
+	}{
+		{
+			name:     "Push name in message wins",
+			info:     types.MessageInfo{MessageSource: types.MessageSource{Sender: senderDevice}, PushName: "Alice"},
+			contact:  types.ContactInfo{FullName: "Alice Address Book", PushName: "Old push name", BusinessName: "Alice Inc"},
+			expected: "Alice",
+		},
+		{
+			name:     "Stored push name",
+			info:     types.MessageInfo{MessageSource: types.MessageSource{Sender: senderDevice}},
+			contact:  types.ContactInfo{FullName: "Alice Address Book", PushName: "Stored push name", BusinessName: "Alice Inc"},
+			expected: "Stored push name",
+		},
+		{
+			name:     "Placeholder push name is ignored",
+			info:     types.MessageInfo{MessageSource: types.MessageSource{Sender: senderDevice}, PushName: "-"},
+			contact:  types.ContactInfo{PushName: "Stored push name"},
+			expected: "Stored push name",
+		},
+		{
+			name:     "Verified name",
+			info:     types.MessageInfo{MessageSource: types.MessageSource{Sender: senderDevice}},
+			contact:  types.ContactInfo{FullName: "Alice Address Book", BusinessName: "Alice Inc"},
+			expected: "Alice Inc",
+		},
+		{
+			name:     "Phone number",
+			info:     types.MessageInfo{MessageSource: types.MessageSource{Sender: senderDevice}},
+			contact:  types.ContactInfo{FullName: "Alice Address Book"},
+			expected: "+1111",
+		},
+		{
+			name:     "Own messages use own push name",
+			info:     types.MessageInfo{MessageSource: types.MessageSource{Sender: senderDevice, IsFromMe: true}, PushName: "Other"},
+			expected: "Me",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cli := NewClient(&store.Device{
+				PushName: "Me",
+				Contacts: &singleContactStore{user: sender, contact: test.contact},
+			}, nil)
+			if name := cli.ResolveDisplayName(test.info); name != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, name)
+			}
+		})
+	}
+}
+
+// respondToUSync answers the next usync query with the given user nodes and returns the tags of the protocols
+// that were requested in the query.
+func respondToUSync(t *testing.T, cli *Client, users []waBinary.Node) func() []string {
+	var lock sync.Mutex
+	var queryTags []string
+	cli.OnFrameSent = func(_ []byte, node *waBinary.Node) {
+		if node == nil || node.Tag != "iq" {
+			return
+		}
+		query := node.GetChildByTag("usync", "query")
+		lock.Lock()
+		defer lock.Unlock()
+		for _, protocol := range query.GetChildren() {
+			queryTags = append(queryTags, protocol.Tag)
+		}
+	}
+	go func() {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			cli.responseWaitersLock.Lock()
+			var id string
+			for id = range cli.responseWaiters {
+				break
+			}
+			cli.responseWaitersLock.Unlock()
+			if id != "" {
+				cli.receiveResponse(&waBinary.Node{
+					Tag:   "iq",
+					Attrs: waBinary.Attrs{"id": id, "type": "result", "from": types.ServerJID},
+					Content: []waBinary.Node{{Tag: "usync", Content: []waBinary.Node{
+						{Tag: "list", Content: users},
+					}}},
+				})
+				return
+			}
+		}
+		t.Error("Timed out waiting for usync query")
+	}()
+	return func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return queryTags
+	}
+}
+
+func TestGetUserInfoWithRaw(t *testing.T) {
+	cli := NewClient(&store.Device{}, nil)
+	connectTestSocket(t, cli)
+	t.Cleanup(cli.Disconnect)
+	alice := types.NewJID("1111", types.DefaultUserServer)
+	getQueryTags := respondToUSync(t, cli, []waBinary.Node{{
+		Tag:   "user",
+		Attrs: waBinary.Attrs{"jid": alice},
+		Content: []waBinary.Node{
+			{Tag: "status", Content: []byte("Hello")},
+			{Tag: "picture", Attrs: waBinary.Attrs{"id": "123"}},
+			{Tag: "unparsed", Attrs: waBinary.Attrs{"value": "raw"}},
+		},
+	}})
+
+	infos, err := cli.GetUserInfoWithRaw([]types.JID{alice}, waBinary.Node{Tag: "unparsed"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectedTags := []string{"business", "status", "picture", "devices", "unparsed"}
+	if tags := getQueryTags(); !reflect.DeepEqual(tags, expectedTags) {
+		t.Errorf("Expected query for %v, got %v", expectedTags, tags)
+	}
+	info, ok := infos[alice]
+	if !ok {
+		t.Fatalf("No info returned for %s: %v", alice, infos)
+	}
+	if info.Status != "Hello" || info.PictureID != "123" {
+		t.Errorf("Parsed fields are wrong: %+v", info.UserInfo)
+	}
+	if info.Raw == nil {
+		t.Fatal("Raw node is missing")
+	}
+	unparsed := info.Raw.GetChildByTag("unparsed")
+	if value := unparsed.AttrGetter().String("value"); value != "raw" {
+		t.Errorf("Expected unparsed value in raw node, got %q", value)
+	}
+}
+
+func TestIsOnWhatsAppWithRaw(t *testing.T) {
+	cli := NewClient(&store.Device{}, nil)
+	connectTestSocket(t, cli)
+	t.Cleanup(cli.Disconnect)
+	alice := types.NewJID("1111", types.DefaultUserServer)
+	bob := types.NewJID("2222", types.DefaultUserServer)
+	user := func(jid types.JID, contactType string) waBinary.Node {
+		return waBinary.Node{
+			Tag:   "user",
+			Attrs: waBinary.Attrs{"jid": jid},
+			Content: []waBinary.Node{
+				{Tag: "contact", Attrs: waBinary.Attrs{"type": contactType}, Content: []byte("+" + jid.User + "@c.us")},
+			},
+		}
+	}
+	respondToUSync(t, cli, []waBinary.Node{user(alice, "in"), user(bob, "out")})
+
+	responses, err := cli.IsOnWhatsAppWithRaw([]string{"+1111", "+2222"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	} else if len(responses) != 2 {
+		t.Fatalf("Expected 2 responses, got %d", len(responses))
+	}
+	for i, expected := range []types.IsOnWhatsAppResponse{
+		{Query: "+1111", JID: alice, IsIn: true},
+		{Query: "+2222", JID: bob, IsIn: false},
+	} {
+		if responses[i].IsOnWhatsAppResponse != expected {
+			t.Errorf("Expected response %+v, got %+v", expected, responses[i].IsOnWhatsAppResponse)
+		}
+		// Each response must have its own raw node rather than sharing the loop variable
+		if jid := responses[i].Raw.AttrGetter().JID("jid"); jid != expected.JID {
+			t.Errorf("Expected raw node of %s, got %s", expected.JID, jid)
+		}
+	}
+}
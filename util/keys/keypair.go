@@ -10,6 +10,7 @@ package keys
 import (
 	"crypto/rand"
 	"fmt"
+	"io"
 
 	"go.mau.fi/libsignal/ecc"
 	"golang.org/x/crypto/curve25519"
@@ -32,9 +33,14 @@ func NewKeyPairFromPrivateKey(priv [32]byte) *KeyPair {
 }
 
 func NewKeyPair() *KeyPair {
+	return NewKeyPairFromReader(rand.Reader)
+}
+
+// NewKeyPairFromReader generates a new keypair using the given randomness source.
+func NewKeyPairFromReader(random io.Reader) *KeyPair {
 	var priv [32]byte
 
-	_, err := rand.Read(priv[:])
+	_, err := io.ReadFull(random, priv[:])
 	if err != nil {
 		panic(fmt.Errorf("failed to generate curve25519 private key: %w", err))
 	}
@@ -47,7 +53,14 @@ func NewKeyPair() *KeyPair {
 }
 
 func (kp *KeyPair) CreateSignedPreKey(keyID uint32) *PreKey {
-	newKey := NewPreKey(keyID)
+	return kp.CreateSignedPreKeyFromReader(keyID, rand.Reader)
+}
+
+// CreateSignedPreKeyFromReader generates a new prekey using the given randomness source and signs it with this keypair.
+//
+// Note that the signature itself is calculated by libsignal, which always uses crypto/rand.
+func (kp *KeyPair) CreateSignedPreKeyFromReader(keyID uint32, random io.Reader) *PreKey {
+	newKey := NewPreKeyFromReader(keyID, random)
 	newKey.Signature = kp.Sign(&newKey.KeyPair)
 	return newKey
 }
@@ -68,8 +81,13 @@ type PreKey struct {
 }
 
 func NewPreKey(keyID uint32) *PreKey {
+	return NewPreKeyFromReader(keyID, rand.Reader)
+}
+
+// NewPreKeyFromReader generates a new prekey using the given randomness source.
+func NewPreKeyFromReader(keyID uint32, random io.Reader) *PreKey {
 	return &PreKey{
-		KeyPair: *NewKeyPair(),
+		KeyPair: *NewKeyPairFromReader(random),
 		KeyID:   keyID,
 	}
 }
@@ -0,0 +1,45 @@
+// Copyright (c) 2022 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package randutil contains helpers for using custom randomness sources for key and ID generation.
+package randutil
+
+import (
+	"crypto/rand"
+	"errors"
+	"flag"
+	"io"
+	mathRand "math/rand"
+)
+
+// ErrWeakSource is returned by Validate if the given randomness source is not cryptographically secure.
+var ErrWeakSource = errors.New("math/rand is not a cryptographically secure randomness source")
+
+// Validate checks that the given reader is usable as a randomness source for key generation.
+//
+// Sources from math/rand are only accepted inside test binaries, so that a deterministic
+// source used for tests can't accidentally end up being used for real keys.
+func Validate(r io.Reader) error {
+	if r == nil {
+		return nil
+	}
+	if _, isMathRand := r.(*mathRand.Rand); isMathRand && !isTestBinary() {
+		return ErrWeakSource
+	}
+	return nil
+}
+
+// OrDefault returns the given reader, or crypto/rand's reader if it's nil.
+func OrDefault(r io.Reader) io.Reader {
+	if r == nil {
+		return rand.Reader
+	}
+	return r
+}
+
+func isTestBinary() bool {
+	return flag.Lookup("test.v") != nil
+}
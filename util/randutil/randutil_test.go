@@ -0,0 +1,21 @@
+package randutil
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/pfthink/whatsmeow/util/keys"
+)
+
+func TestDeterministicKeys(t *testing.T) {
+	if err := Validate(rand.New(rand.NewSource(1))); err != nil {
+		t.Fatalf("math/rand source was rejected in test binary: %v", err)
+	}
+
+	a := keys.NewKeyPairFromReader(rand.New(rand.NewSource(1)))
+	b := keys.NewKeyPairFromReader(rand.New(rand.NewSource(1)))
+	if !bytes.Equal(a.Priv[:], b.Priv[:]) || !bytes.Equal(a.Pub[:], b.Pub[:]) {
+		t.Fail()
+	}
+}